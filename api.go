@@ -2,23 +2,57 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-
-	"github.com/ronna-s/go-chess/chess"
-	"github.com/ronna-s/go-chess/handlers"
-	"github.com/ronna-s/go-chess/namegen"
-	"github.com/ronna-s/go-chess/store"
+	"sync"
+	"time"
+
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/namegen"
+	"github.com/scottcarol/go-chess/projection"
+	"github.com/scottcarol/go-chess/replication"
+	"github.com/scottcarol/go-chess/store"
 	"golang.org/x/net/websocket"
 )
 
+const (
+	maxConflictRetries = 10
+	persistTimeout     = 5 * time.Second
+	wsQueueSize        = 64
+	wsWriteTimeout     = 10 * time.Second
+	sessionCookie      = "session"
+)
+
 type api struct {
 	store *store.EventStore
+	// follower is set when the api serves a read-only replica
+	follower *replication.Follower
+	// adminToken is what requests to the admin endpoints have to carry,
+	// they're refused altogether while it's empty
+	adminToken  string
+	projections *projection.Manager
+	scoreboard  *projection.Scoreboard
+	games       *projection.Games
+	moves       *projection.MoveLists
+
+	metrics *apiMetrics
+
+	// websockets holds the open websockets, they're closed on shutdown
+	websocketsMu sync.Mutex
+	websockets   map[*websocket.Conn]bool
 }
 type Board struct {
 	Squares [][]chess.Square
@@ -27,32 +61,190 @@ type Board struct {
 type page struct {
 	Name  string
 	Board Board
+	// LastEventId is where the page's websocket picks up the game's events from
+	LastEventId int
 }
 
-func newApi(d *store.EventStore) *api {
-	a := api{store: d}
+// newApi registers the game's handlers and read models with d,
+// the read models are saved in projectionsDir unless it's empty.
+// Every handler handles the events of up to workers games at once.
+func newApi(d *store.EventStore, projectionsDir string, workers int) (*api, error) {
+	a := api{
+		store:       d,
+		projections: projection.NewManager(d, projectionsDir),
+		scoreboard:  projection.NewScoreboard(),
+		games:       projection.NewGames(),
+		moves:       projection.NewMoveLists(),
+	}
+	a.metrics = newApiMetrics(&a)
+	for _, p := range []projection.Projection{a.scoreboard, a.games, a.moves} {
+		if err := a.projections.Register(p); err != nil {
+			return nil, err
+		}
+	}
+
+	// a replica's leader runs the handlers, their events come with the rest
+	if d.ReadOnly() {
+		return &a, nil
+	}
 
-	cbs := []func(game handlers.Game, event store.Event, eventStore handlers.EventPersister){
-		handlers.MoveHandler,
-		handlers.PromotionHandler,
-		handlers.GameChangedHandler,
-		handlers.RollbackHandler,
+	// every handler is only handed the event types it acts on
+	cbs := []gameHandler{
+		{"MoveHandler", []int{handlers.EventMoveRequest}, handlers.EventMoveFail, handlers.MoveHandler},
+		{"PromotionHandler", []int{handlers.EventPromotionRequest}, handlers.EventPromotionFail, handlers.PromotionHandler},
+		{"GameChangedHandler", []int{handlers.EventMoveSuccess, handlers.EventPromotionSuccess, handlers.EventRollbackSuccess}, 0, handlers.GameChangedHandler},
+		{"RollbackHandler", []int{handlers.EventRollbackRequest}, 0, handlers.RollbackHandler},
 	}
 
 	for i := range cbs {
-		func(i int) {
-			a.store.Register(store.NewEventHandler(
-				func(store *store.EventStore, event store.Event) {
-					events := handlers.FilterEvents(store.Events(), event.AggregateID)
-					game := handlers.Aggregate(chess.NewGame(), events, event.AggregateID, -1)
-					cbs[i](game, event, store)
-				},
-			),
-			)
-		}(i)
+		h := cbs[i]
+		// handlers keep the default OverflowBlock, a game mustn't skip a request
+		a.store.Register(&store.EventListener{
+			Name:    h.name,
+			Filter:  store.Filter{EventTypes: h.eventTypes},
+			Workers: workers,
+			NotifFn: func(s *store.EventStore, event store.Event) {
+				a.handleEvent(s, h, event)
+			},
+		})
+	}
+
+	// workflows that span several events keep their state in process managers
+	for _, pm := range []*store.ProcessManager{handlers.DrawOffers()} {
+		if err := a.store.StartProcessManager(pm); err != nil {
+			return nil, err
+		}
+	}
+
+	return &a, nil
+}
+
+// gameHandler is a handler of the game's requests
+type gameHandler struct {
+	name       string
+	eventTypes []int
+	// failType is the event telling the client its request failed, 0 if it has none
+	failType int
+	handle   func(game handlers.Game, event store.Event, eventStore handlers.EventPersister) error
+}
+
+// handleEvent rebuilds the game and runs the handler, again whenever another
+// event lands in the game's stream before the handler's own. When that keeps
+// happening the request fails, so the client isn't left waiting on it.
+func (a *api) handleEvent(s *store.EventStore, h gameHandler, event store.Event) {
+	for attempt := 0; attempt < maxConflictRetries; attempt++ {
+		stream := s.ReadStream(event.AggregateID, 0)
+		events := handlers.FilterEvents(stream, event.AggregateID)
+		start := time.Now()
+		game := handlers.AggregateFromSnapshot(s, events, event.AggregateID, -1)
+		a.metrics.timeAggregate(start)
+		persister := a.metrics.countMoves(h.name, &store.VersionedPersister{Store: s, Version: len(stream)})
+		err := h.handle(game, event, persister)
+		var conflict *store.ConflictError
+		if !errors.As(err, &conflict) {
+			if err != nil {
+				log.Println("failed handling event:", event, err)
+			}
+			return
+		}
+	}
+	log.Println("giving up on event after repeated conflicts:", event)
+	reason := fmt.Sprintf("the game kept changing, gave up after %d attempts", maxConflictRetries)
+	if h.failType == 0 {
+		s.AddDeadLetter(h.name, event, reason)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	_, err := s.Persist(ctx, store.Event{
+		AggregateID: event.AggregateID,
+		EventType:   h.failType,
+		EventData:   store.EncodePayload(handlers.FailurePayload{Reason: reason}),
+		Metadata:    store.CausedBy(event),
+	})
+	if err != nil {
+		log.Println("failed persisting the failure of event:", event, err)
+		s.AddDeadLetter(h.name, event, reason)
+	}
+}
+
+// aggregate rebuilds a game from its move list, starting from its latest snapshot.
+// It waits for the list to catch up with the game's latest event first so a
+// browser sees the move it was just told about. The list drops archived
+// games, their moves are read from their archive.
+func (a *api) aggregate(gameID string, movesCount int) handlers.Game {
+	var moves []store.Event
+	if a.store.Archived(gameID) {
+		moves = handlers.GameMoves(a.store.ReadStream(store.ArchiveAggregateID, 0), gameID)
+	} else {
+		if stream := a.store.ReadStream(gameID, 0); len(stream) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+			if err := a.projections.Wait(ctx, a.moves.Name(), stream[len(stream)-1].Id); err != nil {
+				log.Println("serving a stale move list of game", gameID, err)
+			}
+			cancel()
+		}
+		moves = a.moves.Moves(gameID)
+	}
+	defer a.metrics.timeAggregate(time.Now())
+	return handlers.AggregateFromSnapshot(a.store, moves, gameID, movesCount)
+}
+
+// gameOver tells if a game has a result, once the games projection caught up
+// with the game's latest event
+func (a *api) gameOver(gameID string) bool {
+	if stream := a.store.ReadStream(gameID, 0); len(stream) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		if err := a.projections.Wait(ctx, a.games.Name(), stream[len(stream)-1].Id); err != nil {
+			log.Println("checking the result of game", gameID, "on a stale projection", err)
+		}
+		cancel()
 	}
+	game, _ := a.games.Game(gameID)
+	return game.Result != ""
+}
 
-	return &a
+// session returns the id of the browser's session, starting one if it has none
+func (a *api) session(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("failed generating session id:", err)
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true})
+	return id
+}
+
+// adminOnly serves a request with h if it carries the admin token, as in
+// "Authorization: Bearer <token>". The admin endpoints show the log and the
+// server's internals, with no token set nobody gets to them.
+func (a *api) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if a.adminToken == "" || token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "this takes the admin token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// actorOf returns the actor the events of a session are stamped with. The
+// session id lets anyone holding it act as the browser, so it's kept out of
+// the log and a hash of it is stored instead. Session ids are 128 random
+// bits, the hash can't be turned back into one.
+func actorOf(session string) string {
+	if session == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:16])
 }
 
 func (a *api) getOrGenerateGameName(gameID string) string {
@@ -81,18 +273,62 @@ func (a *api) createGameHandler(w http.ResponseWriter, r *http.Request) {
 
 func (a *api) gameHandler(w http.ResponseWriter, r *http.Request) {
 	gameID := a.getOrGenerateGameName(r.URL.Query().Get("game_id"))
-	events := handlers.FilterEvents(a.store.Events(), gameID)
-	game := handlers.Aggregate(chess.NewGame(), events, gameID, -1)
+	lastEventID := a.store.LastID()
+	game := a.aggregate(gameID, -1)
 
 	var b bytes.Buffer
-	t := template.Must(template.ParseFiles( "templates/game.html.tmpl"))
+	t := template.Must(template.ParseFiles("templates/game.html.tmpl"))
 	if err := t.ExecuteTemplate(&b, "base", page{
-		Name: gameID, Board: Board{Squares: game.Draw(), Moves: game.Moves()}}); err != nil {
+		Name: gameID, Board: Board{Squares: game.Draw(), Moves: game.Moves()}, LastEventId: lastEventID}); err != nil {
 		panic(err)
 	}
 	w.Write(b.Bytes())
 }
 
+// command is a request to change a game, as sent by board.js
+type command struct {
+	AggregateId string
+	Type        string
+	Data        string
+	// IdempotencyKey is reused by the client when it retries the command
+	IdempotencyKey string
+}
+
+func (c command) event(actor string) (store.Event, error) {
+	e := store.Event{
+		AggregateID: c.AggregateId,
+		Metadata:    store.Metadata{Actor: actor, IdempotencyKey: c.IdempotencyKey},
+	}
+	var (
+		payload interface{}
+		err     error
+	)
+	switch c.Type {
+	case "move":
+		e.EventType = handlers.EventMoveRequest
+		payload, err = handlers.ParseMove(c.Data)
+	case "promote":
+		e.EventType = handlers.EventPromotionRequest
+		payload, err = handlers.ParsePromotion(c.Data)
+	case "rollback":
+		e.EventType = handlers.EventRollbackRequest
+		payload = handlers.RollbackPayload{}
+	case "offer-draw":
+		e.EventType = handlers.EventDrawOffered
+		payload = handlers.DrawOfferPayload{}
+	case "accept-draw":
+		e.EventType = handlers.EventDrawAccepted
+		payload = handlers.DrawOfferPayload{}
+	case "decline-draw":
+		e.EventType = handlers.EventDrawDeclined
+		payload = handlers.DrawOfferPayload{}
+	default:
+		err = fmt.Errorf("unknown request type %q", c.Type)
+	}
+	e.EventData = store.EncodePayload(payload)
+	return e, err
+}
+
 func (a *api) boardHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		gameID := a.getOrGenerateGameName(r.URL.Query().Get("game_id"))
@@ -102,8 +338,7 @@ func (a *api) boardHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		} else {
-			events := handlers.FilterEvents(a.store.Events(), gameID)
-			game := handlers.Aggregate(chess.NewGame(), events, gameID, int(lastMove))
+			game := a.aggregate(gameID, int(lastMove))
 
 			var b bytes.Buffer
 			t := template.Must(template.ParseFiles("templates/board.html.tmpl"))
@@ -113,26 +348,43 @@ func (a *api) boardHandler(w http.ResponseWriter, r *http.Request) {
 			w.Write(b.Bytes())
 		}
 	} else if r.Method == "POST" {
-		var m struct {
-			AggregateId string
-			Type        string
-			Data        string
+		if a.store.ReadOnly() {
+			http.Error(w, "this is a read-only replica", http.StatusForbidden)
+			return
 		}
-		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		// a retry with the same IdempotencyKey gets the Id of the first request back
+		var c command
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		e := store.Event{AggregateID: m.AggregateId, EventData: m.Data}
-		switch m.Type {
-		case "move":
-			e.EventType = handlers.EventMoveRequest
-		case "promote":
-			e.EventType = handlers.EventPromotionRequest
-		case "rollback":
-			e.EventType = handlers.EventRollbackRequest
+		e, err := c.event(actorOf(a.session(w, r)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch e.EventType {
+		case handlers.EventDrawOffered, handlers.EventDrawAccepted, handlers.EventDrawDeclined:
+			if a.gameOver(e.AggregateID) {
+				http.Error(w, "the game is over", http.StatusConflict)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), persistTimeout)
+		defer cancel()
+		e, err = a.store.Persist(ctx, e)
+		if err == store.ErrArchived {
+			http.Error(w, "the game ended and was archived", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("failed to persist event:", e, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		}
-		a.store.Persist(e)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct{ Id int }{e.Id})
 	}
 
 }
@@ -145,8 +397,7 @@ func (a *api) sliderHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	} else {
-		events := handlers.FilterEvents(a.store.Events(), gameID)
-		game := handlers.Aggregate(chess.NewGame(), events, gameID, int(lastMove))
+		game := a.aggregate(gameID, int(lastMove))
 
 		var b bytes.Buffer
 		t := template.Must(template.ParseFiles("templates/slider.html.tmpl"))
@@ -159,22 +410,56 @@ func (a *api) sliderHandler(w http.ResponseWriter, r *http.Request) {
 
 // debugHandler writes string representation of current board state to http response
 // it doesn't have any information about current game, only a list of moves, from which it builds the state
+// given an event_id instead it writes the causal chain of that event
 func (a *api) debugHandler(w http.ResponseWriter, r *http.Request) {
+	if eventIDStr := r.URL.Query().Get("event_id"); eventIDStr != "" {
+		eventID, err := strconv.Atoi(eventIDStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.debugEventHandler(w, eventID)
+		return
+	}
+
 	gameID := a.getOrGenerateGameName(r.URL.Query().Get("game_id"))
 
-	events := handlers.FilterEvents(a.store.Events(), gameID)
-	game := handlers.Aggregate(chess.NewGame(), events, gameID, -1)
+	game := a.aggregate(gameID, -1)
 
 	if _, err := w.Write([]byte(game.Debug())); err != nil {
 		log.Printf("can't write the response: %v", err)
 	}
 }
 
+// debugEventHandler writes the events that led to an event, the event itself
+// marked with a *, and the events it led to
+func (a *api) debugEventHandler(w http.ResponseWriter, eventID int) {
+	chain := a.store.CausalChain(eventID)
+	if len(chain) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var b bytes.Buffer
+	for i, e := range chain {
+		marker := " "
+		if e.Id == eventID {
+			marker = "*"
+		}
+		fmt.Fprintf(&b, "%s %s%v %v\n", marker, strings.Repeat("  ", i), e, e.Metadata)
+	}
+	for _, e := range a.store.Consequences(eventID) {
+		fmt.Fprintf(&b, "  %s%v %v\n", strings.Repeat("  ", len(chain)), e, e.Metadata)
+	}
+	if _, err := w.Write(b.Bytes()); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
 func (a *api) promotionsHandler(w http.ResponseWriter, r *http.Request) {
 	gameID := a.getOrGenerateGameName(r.URL.Query().Get("game_id"))
 
-	events := handlers.FilterEvents(a.store.Events(), gameID)
-	game := handlers.Aggregate(chess.NewGame(), events, gameID, -1)
+	game := a.aggregate(gameID, -1)
 
 	query := r.URL.Query().Get("target")
 	promotions := game.ValidPromotions(query)
@@ -188,29 +473,78 @@ func (a *api) promotionsHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// wsMessage tells the browser whether an event changed the board (Result "1")
+// or a request failed (Result "0"). Id lets it resume from there when
+// reconnecting, it's -1 for commands rejected before they became an event.
+type wsMessage struct {
+	Id     int
+	Result string
+	// IdempotencyKey is the key of the command the message answers
+	IdempotencyKey string
+}
+
+// commandKey returns the idempotency key of the command that led to e
+func (a *api) commandKey(e store.Event) string {
+	if e.Metadata.CausationID == nil {
+		return ""
+	}
+	stream := a.store.ReadStream(e.AggregateID, 0)
+	for i := e.Version - 2; i >= 0 && i < len(stream); i-- {
+		if stream[i].Id == *e.Metadata.CausationID {
+			return stream[i].Metadata.IdempotencyKey
+		}
+	}
+	return ""
+}
+
 func (a *api) wsEventListener(ws *websocket.Conn, gameId string) *store.EventListener {
-	return store.NewEventHandler(
-		func(eventStore *store.EventStore, e store.Event) {
-			if e.AggregateID == gameId {
-				switch e.EventType {
-				case handlers.EventMoveSuccess,
-					handlers.EventPromotionSuccess,
-					handlers.EventRollbackSuccess:
-					ws.Write([]byte("1"))
-				case handlers.EventMoveFail,
-					handlers.EventPromotionFail:
-					ws.Write([]byte("0"))
-				}
+	filter := store.Filter{
+		AggregateIDs: []string{gameId},
+		EventTypes: []int{
+			handlers.EventMoveSuccess,
+			handlers.EventPromotionSuccess,
+			handlers.EventRollbackSuccess,
+			handlers.EventMoveFail,
+			handlers.EventPromotionFail,
+		},
+	}
+	// a browser that can't keep up is cut off, it catches up when it reconnects
+	return &store.EventListener{
+		Name:      "websocket " + ws.Request().RemoteAddr,
+		Filter:    filter,
+		QueueSize: wsQueueSize,
+		Overflow:  store.OverflowDisconnect,
+		OnDisconnect: func() {
+			ws.Close()
+		},
+		NotifFn: func(eventStore *store.EventStore, e store.Event) {
+			msg := wsMessage{Id: e.Id, Result: "1", IdempotencyKey: a.commandKey(e)}
+			if e.EventType == handlers.EventMoveFail || e.EventType == handlers.EventPromotionFail {
+				msg.Result = "0"
 			}
-		})
+			ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				// the read loop in wsHandler unregisters the listener once it's closed
+				log.Println("failed writing to websocket... closing connection", err)
+				ws.Close()
+			}
+		},
+	}
 }
 
 func (a *api) wsHandler(ws *websocket.Conn) {
 	log.Println("websocket connection initiated")
+	a.trackWebsocket(ws, true)
+	defer a.trackWebsocket(ws, false)
 
+	// the first message says hello, LastEventId is the last event the browser
+	// has seen and anything after it is sent before the live events.
+	// The messages after it are commands, same as the ones posted to /board.
 	var m struct {
-		AggregateId string
+		command
+		LastEventId int
 	}
+	m.LastEventId = -1
 
 	if err := websocket.JSON.Receive(ws, &m); err != nil {
 		log.Println("failed reading json from websocket... closing connection")
@@ -218,18 +552,201 @@ func (a *api) wsHandler(ws *websocket.Conn) {
 	}
 
 	l := a.wsEventListener(ws, m.AggregateId)
-	a.store.Register(l)
+	if err := a.store.Subscribe(l, m.LastEventId); err != nil {
+		log.Println("failed subscribing websocket... closing connection", err)
+		return
+	}
+	var actor string
+	if c, err := ws.Request().Cookie(sessionCookie); err == nil {
+		actor = actorOf(c.Value)
+	}
 	for {
-		if err := websocket.JSON.Receive(ws, &m); err != nil {
+		var c command
+		if err := websocket.JSON.Receive(ws, &c); err != nil {
 			a.store.Unregister(l)
 			log.Println("websocket closed or json invalid... closing connection")
 			return
 		}
+		if c.AggregateId == "" {
+			c.AggregateId = m.AggregateId
+		}
+		a.wsCommand(ws, c, actor)
+	}
+}
+
+func (a *api) trackWebsocket(ws *websocket.Conn, open bool) {
+	a.websocketsMu.Lock()
+	defer a.websocketsMu.Unlock()
+	if !open {
+		delete(a.websockets, ws)
+		return
+	}
+	if a.websockets == nil {
+		a.websockets = map[*websocket.Conn]bool{}
+	}
+	a.websockets[ws] = true
+}
+
+// closeWebsockets closes every open websocket, the browsers reconnect and
+// catch up from their last event
+func (a *api) closeWebsockets() {
+	a.websocketsMu.Lock()
+	defer a.websocketsMu.Unlock()
+	for ws := range a.websockets {
+		ws.Close()
+	}
+}
+
+// wsCommand persists a command received over a websocket, its result is
+// sent by the listener like that of any other command
+func (a *api) wsCommand(ws *websocket.Conn, c command, actor string) {
+	e, err := c.event(actor)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		_, err = a.store.Persist(ctx, e)
+		cancel()
+	}
+	if err != nil {
+		log.Println("failed to persist websocket command:", c, err)
+		ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		websocket.JSON.Send(ws, wsMessage{Id: -1, Result: "0", IdempotencyKey: c.IdempotencyKey})
+	}
+}
+
+// deadLettersHandler lists the events listeners failed to handle
+func (a *api) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.store.DeadLetters()); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// gamesHandler lists the games that haven't ended, or with ?game_id= sums up one game
+func (a *api) gamesHandler(w http.ResponseWriter, r *http.Request) {
+	var data interface{} = a.games.Active()
+	if gameID := r.URL.Query().Get("game_id"); gameID != "" {
+		game, ok := a.games.Game(gameID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data = game
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// projectionsHandler lists how far every read model got,
+// POST ?name= rebuilds one from scratch
+func (a *api) projectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := a.projections.Rebuild(r.URL.Query().Get("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.projections.Checkpoints()); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// asOfState is what the games looked like once an event was appended
+type asOfState struct {
+	EventID int
+	Scores  []projection.Score
+	Active  []projection.GameSummary
+	Game    *asOfGame `json:",omitempty"`
+}
+
+type asOfGame struct {
+	projection.GameSummary
+	MoveList []string
+	FEN      string
+	Board    string
+}
+
+// asOfHandler rebuilds the scoreboard and the games as they were once the
+// event ?event_id= was appended, or as of ?time= (RFC 3339).
+// With ?game_id= it adds that game's board.
+func (a *api) asOfHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var id int
+	switch {
+	case q.Get("event_id") != "":
+		var err error
+		if id, err = strconv.Atoi(q.Get("event_id")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case q.Get("time") != "":
+		t, err := time.Parse(time.RFC3339, q.Get("time"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id = a.store.IDAsOf(t)
+	default:
+		http.Error(w, "either event_id or time is needed", http.StatusBadRequest)
+		return
+	}
+
+	events := a.store.AsOf(id)
+	scoreboard, games := projection.NewScoreboard(), projection.NewGames()
+	projection.Replay(events, scoreboard, games)
+	state := asOfState{EventID: id, Scores: scoreboard.Scores(), Active: games.Active()}
+	if gameID := q.Get("game_id"); gameID != "" {
+		summary, ok := games.Game(gameID)
+		if !ok {
+			http.Error(w, "there was no such game by then", http.StatusNotFound)
+			return
+		}
+		game := handlers.Aggregate(chess.NewGame(), handlers.GameMoves(events, gameID), gameID, -1)
+		state.Game = &asOfGame{GameSummary: summary, MoveList: game.Moves(), FEN: game.FEN(), Board: game.Debug()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// replicationStatusHandler tells how far a replica is behind its leader
+func (a *api) replicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if a.follower == nil {
+		http.Error(w, "this isn't a replica", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.follower.Status()); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// scheduledHandler lists the events waiting to be persisted, the soonest first
+func (a *api) scheduledHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.store.Scheduled()); err != nil {
+		log.Printf("can't write the response: %v", err)
+	}
+}
+
+// verifyHandler walks the hash chain of the log and reports the first broken link
+func (a *api) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := a.store.VerifyChain()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("can't write the response: %v", err)
 	}
 }
 
 func (a *api) scoreHandler(w http.ResponseWriter, r *http.Request) {
-	data := handlers.BuildScores(a.store)
+	data := a.scoreboard.Scores()
 	var b bytes.Buffer
 	t := template.Must(template.ParseFiles("templates/scoreboard.html.tmpl"))
 	if err := t.ExecuteTemplate(&b, "score_board", data); err != nil {
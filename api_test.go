@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/replication"
+	"github.com/scottcarol/go-chess/store"
+	"golang.org/x/net/websocket"
+)
+
+// seedGames adds games moves deep each to the store
+func seedGames(s *store.EventStore, games int, prefix string) {
+	moves := []string{"12-28", "52-36", "6-21", "57-42", "5-26", "62-45"}
+	for g := 0; g < games; g++ {
+		gameID := fmt.Sprintf("%s-%d", prefix, g)
+		for _, m := range moves {
+			p, _ := handlers.ParseMove(m)
+			data := store.EncodePayload(p)
+			s.AddEvent(store.Event{AggregateID: gameID, EventType: handlers.EventMoveRequest, EventData: data})
+			s.AddEvent(store.Event{AggregateID: gameID, EventType: handlers.EventMoveSuccess, EventData: data})
+		}
+	}
+}
+
+// testApi returns an api with its read models kept in memory
+func testApi(t testing.TB, s *store.EventStore) *api {
+	a, err := newApi(s, "", runtime.NumCPU())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestBoardHandlerPostReturnsEventID(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	seedGames(s, 1, "other")
+	a := &api{store: s}
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"AggregateId": "my game", "Type": "move", "Data": "12-28"}`)
+	a.boardHandler(w, httptest.NewRequest(http.MethodPost, "/board?game_id=my+game", body))
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("expected status 201 but got", w.Code)
+	}
+	var created struct{ Id int }
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	events := s.ReadStream("my game", 0)
+	if len(events) != 1 || events[0].Id != created.Id || events[0].EventType != handlers.EventMoveRequest {
+		t.Error("expected the response to hold the Id of", events, "but got", created.Id)
+	}
+}
+
+func TestBoardHandlerPostIsIdempotent(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	a := &api{store: s}
+
+	var ids []int
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"AggregateId": "my game", "Type": "move", "Data": "12-28", "IdempotencyKey": "retried"}`)
+		a.boardHandler(w, httptest.NewRequest(http.MethodPost, "/board?game_id=my+game", body))
+		var created struct{ Id int }
+		if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.Id)
+	}
+	if ids[0] != ids[1] {
+		t.Error("expected the retry to get the Id of the first request but got", ids)
+	}
+	if events := s.ReadStream("my game", 0); len(events) != 1 {
+		t.Error("expected the move to be requested once but got", events)
+	}
+}
+
+func TestWebsocketCommand(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	a := testApi(t, s)
+	srv := httptest.NewServer(websocket.Handler(a.wsHandler))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, map[string]interface{}{"Type": "hello", "AggregateId": "my game"})
+	websocket.JSON.Send(ws, command{Type: "move", Data: "12-28", IdempotencyKey: "first"})
+	websocket.JSON.Send(ws, command{Type: "fly", IdempotencyKey: "second"})
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	results := map[string]string{}
+	for len(results) < 2 {
+		var got wsMessage
+		if err := websocket.JSON.Receive(ws, &got); err != nil {
+			t.Fatal("failed reading with", err)
+		}
+		results[got.IdempotencyKey] = got.Result
+	}
+	if results["first"] != "1" || results["second"] != "0" {
+		t.Error("expected the move to succeed and the unknown command to be rejected but got", results)
+	}
+}
+
+func TestWebsocketResumesFromLastEventID(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	seedGames(s, 1, "mine")
+	a := &api{store: s}
+	srv := httptest.NewServer(websocket.Handler(a.wsHandler))
+	defer srv.Close()
+
+	stream := s.ReadStream("mine-0", 0)
+	// the browser saw the first 3 moves (request and success each)
+	lastSeen := stream[5].Id
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"AggregateId": "mine-0", "LastEventId": lastSeen}); err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := s.Persist(context.Background(), store.Event{AggregateID: "mine-0", EventType: handlers.EventMoveFail})
+
+	var expected []wsMessage
+	for _, e := range stream[6:] {
+		if e.EventType == handlers.EventMoveSuccess {
+			expected = append(expected, wsMessage{Id: e.Id, Result: "1"})
+		}
+	}
+	expected = append(expected, wsMessage{Id: live.Id, Result: "0"})
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range expected {
+		var got wsMessage
+		if err := websocket.JSON.Receive(ws, &got); err != nil {
+			t.Fatal("expected", want, "but failed reading with", err)
+		}
+		if got != want {
+			t.Error("expected", want, "but received", got)
+		}
+	}
+}
+
+// BenchmarkBoardHandler shows that loading a game's board costs the same
+// regardless of how many other games are in the store
+func BenchmarkBoardHandler(b *testing.B) {
+	for _, otherGames := range []int{0, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("otherGames=%d", otherGames), func(b *testing.B) {
+			s := store.NewEventStore()
+			s.Run()
+			seedGames(s, otherGames, "other")
+			seedGames(s, 1, "mine")
+			a := testApi(b, s)
+			r := httptest.NewRequest(http.MethodGet, "/board?game_id=mine-0&last_move=-1", nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				a.boardHandler(w, r)
+				if w.Code != http.StatusOK {
+					b.Fatal("unexpected status", w.Code)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDebugHandler(b *testing.B) {
+	for _, otherGames := range []int{0, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("otherGames=%d", otherGames), func(b *testing.B) {
+			s := store.NewEventStore()
+			s.Run()
+			seedGames(s, otherGames, "other")
+			seedGames(s, 1, "mine")
+			a := testApi(b, s)
+			r := httptest.NewRequest(http.MethodGet, "/debug?game_id=mine-0", nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				a.debugHandler(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+func TestArchivedGameIsStillServed(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+
+	// fool's mate
+	for _, m := range []string{"13-21", "52-36", "14-30", "59-31"} {
+		e, _ := command{AggregateId: "mated", Type: "move", Data: m}.event("")
+		if _, err := s.Persist(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if game, _ := a.games.Game("mated"); game.Result != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the game to end")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	board := func() string {
+		w := httptest.NewRecorder()
+		a.boardHandler(w, httptest.NewRequest(http.MethodGet, "/board?game_id=mated&last_move=-1", nil))
+		return w.Body.String()
+	}
+	before := board()
+	if before == "" {
+		t.Fatal("expected the game's board")
+	}
+
+	arch := &archiver{store: s, games: a.games, grace: time.Hour}
+	if n, err := arch.archiveFinished(time.Now()); err != nil || n != 0 {
+		t.Fatal("expected the game to wait out its grace period but archived", n, err)
+	}
+	if n, err := arch.archiveFinished(time.Now().Add(2 * time.Hour)); err != nil || n != 1 {
+		t.Fatal("expected to archive the game but archived", n, err)
+	}
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if stream := s.ReadStream("mated", 0); len(stream) != 0 {
+		t.Error("expected the game's events to be compacted away but got", stream)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.projections.Wait(ctx, a.moves.Name(), s.LastID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if moves := a.moves.Moves("mated"); len(moves) != 0 {
+		t.Error("expected the move lists to drop the archived game but got", moves)
+	}
+	if after := board(); after != before {
+		t.Error("expected the archived game's board", before, "but got", after)
+	}
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"AggregateId": "mated", "Type": "move", "Data": "12-28"}`)
+	a.boardHandler(w, httptest.NewRequest(http.MethodPost, "/board?game_id=mated", body))
+	if w.Code != http.StatusConflict {
+		t.Error("expected a move in an archived game to be refused but got", w.Code)
+	}
+}
+
+func TestNoDrawAfterMate(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+	post := func(session, typ, data string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/board", strings.NewReader(fmt.Sprintf(`{"AggregateId": "mated", "Type": %q, "Data": %q}`, typ, data)))
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+		a.boardHandler(w, r)
+		return w.Code
+	}
+
+	// an offer that still stands when the game ends in fool's mate
+	if code := post("alice's session", "offer-draw", ""); code != http.StatusCreated {
+		t.Fatal("expected the offer to be taken but got", code)
+	}
+	for _, m := range []string{"13-21", "52-36", "14-30", "59-31"} {
+		if code := post("alice's session", "move", m); code != http.StatusCreated {
+			t.Fatal("expected the move to be taken but got", code)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if game, _ := a.games.Game("mated"); game.Result != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the game to end")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	game, _ := a.games.Game("mated")
+	scores := a.scoreboard.Scores()
+
+	for _, c := range []struct{ session, typ string }{
+		{"bob's session", "accept-draw"},
+		{"alice's session", "offer-draw"},
+		{"bob's session", "accept-draw"},
+		{"bob's session", "decline-draw"},
+	} {
+		if code := post(c.session, c.typ, ""); code != http.StatusConflict {
+			t.Errorf("expected %s after mate to be refused but got %d", c.typ, code)
+		}
+	}
+	for _, e := range s.ReadStream("mated", 0) {
+		if e.EventType == handlers.EventDraw {
+			t.Error("expected no draw after mate but got", e)
+		}
+	}
+	if after, _ := a.games.Game("mated"); after.Result != game.Result {
+		t.Error("expected the result", game.Result, "to stay but got", after.Result)
+	}
+	if after := a.scoreboard.Scores(); !reflect.DeepEqual(after, scores) {
+		t.Error("expected the scores", scores, "to stay but got", after)
+	}
+}
+
+func TestRolledBackGameIsNotArchived(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+	result := func(want func(string) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if game, _ := a.games.Game("mated"); want(game.Result) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected the game's result to change")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// fool's mate, then the mate is taken back
+	for _, m := range []string{"13-21", "52-36", "14-30", "59-31"} {
+		e, _ := command{AggregateId: "mated", Type: "move", Data: m}.event("")
+		if _, err := s.Persist(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result(func(r string) bool { return r != "" })
+	e, _ := command{AggregateId: "mated", Type: "rollback"}.event("")
+	if _, err := s.Persist(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	result(func(r string) bool { return r == "" })
+
+	arch := &archiver{store: s, games: a.games}
+	if n, err := arch.archiveFinished(time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Error("expected the game that's on again to be left alone but archived", n, err)
+	}
+	if s.Archived("mated") {
+		t.Error("expected the game not to be archived")
+	}
+}
+
+func TestShutdownLosesNoAcknowledgedCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-chess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := store.NewFileEventStore(dir, handlers.EventTypes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Run()
+	a, err := newApi(s, filepath.Join(dir, "projections"), runtime.NumCPU())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(a.boardHandler))
+
+	var (
+		mu           sync.Mutex
+		acknowledged []int
+		wg           sync.WaitGroup
+	)
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				body := fmt.Sprintf(`{"AggregateId": "game-%d", "Type": "move", "Data": "%d-%d"}`, c, 8+i%8, 16+i%8)
+				resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+				if err != nil {
+					return
+				}
+				var created struct{ Id int }
+				err = json.NewDecoder(resp.Body).Decode(&created)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusCreated || err != nil {
+					return
+				}
+				mu.Lock()
+				acknowledged = append(acknowledged, created.Id)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx, srv.Config, a, s); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	srv.Close()
+
+	restarted, err := store.NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, answered := map[int]bool{}, map[int]bool{}
+	for _, e := range restarted.Events() {
+		if e.EventType == handlers.EventMoveRequest {
+			requests[e.Id] = true
+		}
+		if e.Metadata.CausationID != nil {
+			answered[*e.Metadata.CausationID] = true
+		}
+	}
+	if len(acknowledged) == 0 {
+		t.Fatal("expected some moves to be acknowledged before the shutdown")
+	}
+	for _, id := range acknowledged {
+		if !requests[id] || !answered[id] {
+			t.Fatal("expected acknowledged move", id, "to be in the log and answered, in the log:", requests[id])
+		}
+	}
+}
+
+// BenchmarkConcurrentGames plays the opening of hundreds of games at once
+// through the game's handlers
+func BenchmarkConcurrentGames(b *testing.B) {
+	moves := []string{"12-28", "52-36", "6-21", "57-42", "5-26", "62-45"}
+	for _, games := range []int{100, 500} {
+		for _, workers := range []int{1, 8, 32} {
+			b.Run(fmt.Sprintf("games=%d/workers=%d", games, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					s := store.NewEventStore()
+					s.Run()
+					a, err := newApi(s, "", workers)
+					if err != nil {
+						b.Fatal(err)
+					}
+					var played sync.WaitGroup
+					played.Add(games * len(moves))
+					s.Register(store.NewFilteredEventHandler(
+						store.Filter{EventTypes: []int{handlers.EventMoveSuccess, handlers.EventMoveFail}},
+						func(*store.EventStore, store.Event) { played.Done() },
+					))
+
+					var wg sync.WaitGroup
+					for g := 0; g < games; g++ {
+						wg.Add(1)
+						go func(g int) {
+							defer wg.Done()
+							for _, m := range moves {
+								e, _ := command{AggregateId: fmt.Sprintf("game-%d", g), Type: "move", Data: m}.event("")
+								s.Persist(context.Background(), e)
+							}
+						}(g)
+					}
+					wg.Wait()
+					played.Wait()
+
+					b.StopTimer()
+					if err := shutdown(context.Background(), &http.Server{}, a, s); err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+	for _, m := range []string{"12-28", "52-20"} {
+		e, _ := command{AggregateId: "my game", Type: "move", Data: m}.event("")
+		s.Persist(context.Background(), e)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.metrics.moves.Value("MoveHandler", "fail") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the moves to be handled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.metrics.instrument("/board", a.boardHandler)(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/board?game_id=my+game&last_move=-1", nil))
+
+	w := httptest.NewRecorder()
+	a.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`chess_events_appended_total{type="MoveRequested"} 2`,
+		`chess_events_appended_total{type="MoveSucceeded"} 1`,
+		`chess_moves_total{handler="MoveHandler",result="fail"} 1`,
+		`chess_moves_total{handler="MoveHandler",result="success"} 1`,
+		`chess_listener_queue_depth{listener="MoveHandler"} 0`,
+		`chess_websocket_connections 0`,
+		`chess_http_request_duration_seconds_count{handler="/board"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), "\n"+line+"\n") {
+			t.Error("expected", line, "in", w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), "\nchess_aggregate_duration_seconds_count ") {
+		t.Error("expected game rebuilds to be timed in", w.Body.String())
+	}
+}
+
+func TestNoMetricsCountNoMoves(t *testing.T) {
+	var m *apiMetrics
+	p := &store.VersionedPersister{Store: store.NewEventStore()}
+	if counted := m.countMoves("move", p); counted != p {
+		t.Error("expected an api without metrics to hand the persister back but got", counted)
+	}
+}
+
+func TestAsOfHandler(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+
+	// play persists a command and waits for the game to take its outcome,
+	// it returns the Id of the outcome and the FEN of the game after it
+	play := func(gameID, typ, data string) (int, string) {
+		e, _ := command{AggregateId: gameID, Type: typ, Data: data}.event("")
+		e, err := s.Persist(context.Background(), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if game, _ := a.games.Game(gameID); game.LastEventID > e.Id {
+				return game.LastEventID, a.aggregate(gameID, -1).FEN()
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected an outcome of", e)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	asOf := func(query string) (asOfState, int) {
+		w := httptest.NewRecorder()
+		a.asOfHandler(w, httptest.NewRequest(http.MethodGet, "/admin/asof?"+query, nil))
+		var state asOfState
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return state, w.Code
+	}
+
+	// fool's mate, archived and compacted away
+	var mid int
+	for i, m := range []string{"13-21", "52-36", "14-30", "59-31"} {
+		id, _ := play("mated", "move", m)
+		if i == 1 {
+			mid = id
+		}
+	}
+	// the win comes after the outcome of the last move
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if game, _ := a.games.Game("mated"); game.Result != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the game to end")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	arch := &archiver{store: s, games: a.games}
+	if n, err := arch.archiveFinished(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatal("expected to archive the game but archived", n, err)
+	}
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := asOf(fmt.Sprintf("event_id=%d&game_id=mated", mid))
+	if state.Game == nil || len(state.Game.MoveList) != 2 || state.Game.Result != "" || len(state.Scores) != 0 {
+		t.Error("expected the compacted game 2 moves in and not over but got", state.Game, state.Scores)
+	}
+
+	first, afterFirst := play("disputed", "move", "12-28")
+	second, _ := play("disputed", "move", "52-36")
+	rolledBack, afterRollback := play("disputed", "rollback", "")
+	last, afterLast := play("disputed", "move", "51-35")
+	if afterRollback != afterFirst {
+		t.Fatal("expected the rollback to take the game back to its first move")
+	}
+	for _, c := range []struct {
+		query string
+		moves int
+		fen   string
+	}{
+		{fmt.Sprintf("event_id=%d", first), 1, afterFirst},
+		{fmt.Sprintf("event_id=%d", second), 2, ""},
+		{fmt.Sprintf("event_id=%d", rolledBack), 1, afterRollback},
+		{fmt.Sprintf("event_id=%d", last), 2, afterLast},
+		{"time=" + time.Now().Add(time.Minute).Format(time.RFC3339), 2, afterLast},
+	} {
+		state, code := asOf(c.query + "&game_id=disputed")
+		if code != http.StatusOK || state.Game == nil {
+			t.Error("expected the game as of", c.query, "but got", code)
+			continue
+		}
+		if len(state.Game.MoveList) != c.moves || (c.fen != "" && state.Game.FEN != c.fen) {
+			t.Error("expected", c.moves, "moves and", c.fen, "as of", c.query, "but got", state.Game.MoveList, state.Game.FEN)
+		}
+		if len(state.Scores) != 1 || state.Scores[0].GameName != "mated" {
+			t.Error("expected the archived game's score as of", c.query, "but got", state.Scores)
+		}
+	}
+
+	if _, code := asOf(fmt.Sprintf("event_id=%d&game_id=disputed", mid)); code != http.StatusNotFound {
+		t.Error("expected no game before its first event but got", code)
+	}
+	if _, code := asOf("time=yesterday"); code != http.StatusBadRequest {
+		t.Error("expected a bad time to be refused but got", code)
+	}
+}
+
+func TestHandlerFailsRequestAfterRepeatedConflicts(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	a := &api{store: s}
+	a.metrics = newApiMetrics(a)
+	conflicting := func(handlers.Game, store.Event, handlers.EventPersister) error {
+		return &store.ConflictError{AggregateID: "busy"}
+	}
+
+	request, err := s.AddEvent(store.Event{AggregateID: "busy", EventType: handlers.EventMoveRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.handleEvent(s, gameHandler{name: "MoveHandler", failType: handlers.EventMoveFail, handle: conflicting}, request)
+	stream := s.ReadStream("busy", 0)
+	last := stream[len(stream)-1]
+	if last.EventType != handlers.EventMoveFail || last.Metadata.CausationID == nil || *last.Metadata.CausationID != request.Id {
+		t.Error("expected the move to fail so the client hears back but got", stream)
+	}
+
+	rollback, _ := s.AddEvent(store.Event{AggregateID: "busy", EventType: handlers.EventRollbackRequest})
+	a.handleEvent(s, gameHandler{name: "RollbackHandler", handle: conflicting}, rollback)
+	dead := s.DeadLetters()
+	if len(dead) != 1 || dead[0].Event.Id != rollback.Id || dead[0].Listener != "RollbackHandler" {
+		t.Error("expected a request with no failure event to be dead-lettered but got", dead)
+	}
+}
+
+func TestSessionStaysOutOfTheLog(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	a := &api{store: s}
+	const session = "0123456789abcdef0123456789abcdef"
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/board", strings.NewReader(`{"AggregateId": "my game", "Type": "move", "Data": "12-28"}`))
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	a.boardHandler(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatal("expected status 201 but got", w.Code)
+	}
+	e := s.ReadStream("my game", 0)[0]
+	if e.Metadata.Actor == "" || e.Metadata.Actor != actorOf(session) {
+		t.Error("expected the event to be stamped with the session's actor but got", e.Metadata.Actor)
+	}
+
+	w = httptest.NewRecorder()
+	a.debugHandler(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/debug?event_id=%d", e.Id), nil))
+	if strings.Contains(w.Body.String(), session) {
+		t.Error("expected the session id to stay out of the log but /debug shows it:", w.Body.String())
+	}
+}
+
+func TestDrawOfferNeedsAnotherSession(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+	post := func(session, typ string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/board", strings.NewReader(fmt.Sprintf(`{"AggregateId": "drawn", "Type": %q}`, typ)))
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+		a.boardHandler(w, r)
+		var created struct{ Id int }
+		if err := json.NewDecoder(w.Body).Decode(&created); err != nil || w.Code != http.StatusCreated {
+			t.Fatal("expected status 201 but got", w.Code, err)
+		}
+		return created.Id
+	}
+
+	post("alice's session", "offer-draw")
+	post("alice's session", "accept-draw")
+	accept := post("bob's session", "accept-draw")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stream := s.ReadStream("drawn", 0)
+		if last := stream[len(stream)-1]; last.EventType == handlers.EventDraw {
+			// the offer is handled in order, a draw caused by an earlier accept would be in first
+			if last.Metadata.CausationID == nil || *last.Metadata.CausationID != accept {
+				t.Error("expected only another session to accept the offer but got", stream)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected another session's accept to draw the game")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminEndpointsTakeTheToken(t *testing.T) {
+	s := store.NewBackendEventStore(store.NewMemoryBackend(), handlers.EventTypes())
+	s.Run()
+	a := testApi(t, s)
+	a.adminToken = "the admin token"
+	srv := httptest.NewServer(a.routes())
+	defer srv.Close()
+	get := func(path, auth string) int {
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, path := range []string{"/admin/deadletters", "/admin/projections", "/admin/verify", "/admin/scheduled",
+		"/admin/replication", "/admin/asof", "/metrics", "/replication"} {
+		for _, auth := range []string{"", "Bearer someone else's token", "the admin token"} {
+			if code := get(path, auth); code != http.StatusUnauthorized {
+				t.Errorf("expected %s with %q to be refused but got %d", path, auth, code)
+			}
+		}
+	}
+	for _, path := range []string{"/admin/deadletters", "/metrics"} {
+		if code := get(path, "Bearer the admin token"); code != http.StatusOK {
+			t.Errorf("expected %s to be served with the token but got %d", path, code)
+		}
+	}
+
+	// a replica sends the token along
+	e, _ := command{AggregateId: "some game", Type: "move", Data: "12-28"}.event("")
+	if _, err := s.Persist(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	replica := store.NewEventStore()
+	replica.SetReadOnly()
+	replica.Run()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go replication.NewFollower(replica, srv.URL, a.adminToken).Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for replica.LastID() < e.Id {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the replica to follow the leader with the token")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	a.adminToken = ""
+	if code := get("/admin/deadletters", "Bearer "); code != http.StatusUnauthorized {
+		t.Error("expected the admin endpoints to be closed without a token but got", code)
+	}
+}
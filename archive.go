@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/projection"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// maxArchiveInterval bounds how long the archiver waits between looking for games to archive
+const maxArchiveInterval = 10 * time.Minute
+
+// archiver archives the games that ended longer than grace ago and compacts
+// their events out of the log
+type archiver struct {
+	store *store.EventStore
+	games *projection.Games
+	grace time.Duration
+}
+
+// archiveFinished archives the games that ended before now less the grace
+// period and returns how many it archived. A game played on after it ended,
+// as when a move is rolled back, waits for another grace period.
+func (a *archiver) archiveFinished(now time.Time) (int, error) {
+	archived := 0
+	for _, game := range a.games.Finished() {
+		if now.Sub(game.LastPlayed) < a.grace {
+			break
+		}
+		stream := a.store.ReadStream(game.ID, 0)
+		summary, ok := handlers.ArchiveGame(stream)
+		if !ok {
+			continue
+		}
+		_, err := a.store.Archive(game.ID, len(stream), summary)
+		var conflict *store.ConflictError
+		if errors.As(err, &conflict) {
+			// an event came in after the summary was made, the game is
+			// looked at again on the next pass
+			log.Println("not archiving game", game.ID, "it changed while it was summed up:", err)
+			continue
+		}
+		if err != nil && err != store.ErrArchived {
+			return archived, err
+		}
+		archived++
+	}
+	return archived, nil
+}
+
+// run archives finished games and compacts the log until ctx is done.
+// A replica doesn't archive, its leader does, but it compacts its own log
+// once the archives come in.
+func (a *archiver) run(ctx context.Context) {
+	interval := a.grace
+	if interval > maxArchiveInterval {
+		interval = maxArchiveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !a.store.ReadOnly() {
+			if n, err := a.archiveFinished(time.Now()); err != nil {
+				log.Println("failed archiving games:", err)
+			} else if n > 0 {
+				log.Println("archived", n, "games")
+			}
+		}
+		if n, err := a.store.Compact(); err != nil {
+			log.Println("failed compacting the log:", err)
+		} else if n > 0 {
+			log.Println("compacted", n, "archived events out of the log")
+		}
+	}
+}
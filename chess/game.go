@@ -2,6 +2,9 @@ package chess
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/notnil/chess"
 )
@@ -11,16 +14,74 @@ type (
 		ValidMoves() []*chess.Move
 		Move(*chess.Move) error
 		Position() *chess.Position
+		Positions() []*chess.Position
 		Outcome() chess.Outcome
 		Moves() []*chess.Move
 	}
 	Game struct {
 		ptr game
+		// start is the FEN the game was restored from, empty for a new game
+		start string
+		// history holds the moves played before start
+		history []string
 	}
 )
 
 func NewGame() *Game {
-	return &Game{chess.NewGame()}
+	return &Game{ptr: chess.NewGame()}
+}
+
+// NewGameFromFEN restores a game from the position in fen,
+// moves are the moves that were played to reach it
+func NewGameFromFEN(fen string, moves []string) (*Game, error) {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	return &Game{ptr: chess.NewGame(opt), start: fen, history: moves}, nil
+}
+
+// RestoreGame restores a game from a position Repetitions returned by playing
+// since on it, so repetitions are counted against the same positions as in
+// the game it came from. history are the moves played to reach fen.
+func RestoreGame(fen string, since, history []string) (*Game, error) {
+	g, err := NewGameFromFEN(fen, history)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range since {
+		if strings.Count(query, "-") == 2 {
+			err = g.Promote(query)
+		} else {
+			err = g.Move(query)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't replay %s on %s: %v", query, fen, err)
+		}
+	}
+	return g, nil
+}
+
+// Repetitions returns the position after the last capture or pawn move and the
+// moves played since, the way Move and Promote take them. No position before
+// it can come up again, so the ones after it are all a repetition is counted against.
+func (g *Game) Repetitions() (fen string, since []string) {
+	moves := g.ptr.Moves()
+	// the halfmove clock counts the moves since the last capture or pawn move
+	from := 0
+	if fields := strings.Fields(g.FEN()); len(fields) == 6 {
+		if clock, err := strconv.Atoi(fields[4]); err == nil && clock <= len(moves) {
+			from = len(moves) - clock
+		}
+	}
+	for _, m := range moves[from:] {
+		query := fmt.Sprintf("%d-%d", m.S1(), m.S2())
+		if promo := m.Promo().String(); promo != "" {
+			query += "-" + promo
+		}
+		since = append(since, query)
+	}
+	return g.ptr.Positions()[from].String(), since
 }
 
 func (g *Game) Move(query string) error {
@@ -50,17 +111,41 @@ func (g *Game) Promote(query string) error {
 	return errors.New("promotion is invalid")
 }
 
+// ValidMoves returns every move that can be played now, the way Move takes
+// them or, for promotions, the way Promote does
+func (g *Game) ValidMoves() []string {
+	validMoves := g.ptr.ValidMoves()
+	queries := make([]string, len(validMoves))
+	for i, move := range validMoves {
+		queries[i] = fmt.Sprintf("%d-%d", move.S1(), move.S2())
+		if promo := move.Promo().String(); promo != "" {
+			queries[i] += "-" + promo
+		}
+	}
+	return queries
+}
+
 func (g *Game) Moves() []string {
 	newGame := chess.NewGame()
-	strs := make([]string, len(g.ptr.Moves()))
+	if g.start != "" {
+		opt, _ := chess.FEN(g.start)
+		newGame = chess.NewGame(opt)
+	}
 	moves := g.ptr.Moves()
+	strs := make([]string, len(g.history), len(g.history)+len(moves))
+	copy(strs, g.history)
 	for i := range moves {
-		strs[i] = chess.AlgebraicNotation{}.Encode(newGame.Position(), moves[i])
+		strs = append(strs, chess.AlgebraicNotation{}.Encode(newGame.Position(), moves[i]))
 		newGame.Move(moves[i])
 	}
 	return strs
 }
 
+// FEN returns the current position in Forsyth–Edwards Notation
+func (g *Game) FEN() string {
+	return g.ptr.Position().String()
+}
+
 func (g *Game) Draw() [][]Square {
 	board := make([][]Square, 8)
 	isWhite := false
@@ -1,6 +1,8 @@
 package chess
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"errors"
@@ -30,9 +32,12 @@ func (g *fakeGame) Outcome() chess.Outcome {
 func (g *fakeGame) Moves() []*chess.Move {
 	return nil
 }
+func (g *fakeGame) Positions() []*chess.Position {
+	return []*chess.Position{g.positionFn()}
+}
 func TestGame_Move(t *testing.T) {
 	f := &fakeGame{}
-	g := Game{f}
+	g := Game{ptr: f}
 	f.positionFn = func() *chess.Position {
 		return chess.NewGame().Position()
 	}
@@ -63,7 +68,7 @@ func TestGame_Move(t *testing.T) {
 
 func TestGame_Promote(t *testing.T) {
 	f := &fakeGame{}
-	g := Game{f}
+	g := Game{ptr: f}
 	var position *chess.Position
 	f.positionFn = func() *chess.Position {
 		return position
@@ -99,3 +104,98 @@ func TestGame_Promote(t *testing.T) {
 	}
 
 }
+
+func TestNewGameFromFEN(t *testing.T) {
+	g := NewGame()
+	for _, m := range []string{"12-28", "52-36", "6-21"} {
+		if err := g.Move(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored, err := NewGameFromFEN(g.FEN(), g.Moves())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.FEN() != g.FEN() {
+		t.Error("expected position", g.FEN(), "but got", restored.FEN())
+	}
+
+	g.Move("57-42")
+	if err := restored.Move("57-42"); err != nil {
+		t.Fatal("expected to keep playing from the restored position but failed with", err)
+	}
+	if !reflect.DeepEqual(restored.Moves(), g.Moves()) {
+		t.Error("expected moves", g.Moves(), "but got", restored.Moves())
+	}
+
+	if _, err := NewGameFromFEN("not a position", nil); err == nil {
+		t.Error("expected an invalid FEN to fail")
+	}
+}
+
+func TestValidMoves(t *testing.T) {
+	moves := NewGame().ValidMoves()
+	if len(moves) != 20 {
+		t.Error("expected 20 opening moves but got", moves)
+	}
+	for _, m := range moves {
+		if err := NewGame().Move(m); err != nil {
+			t.Error("expected", m, "to be playable but got", err)
+		}
+	}
+
+	g, err := NewGameFromFEN("8/P7/8/8/8/8/8/k6K w - - 0 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	promotions := 0
+	for _, m := range g.ValidMoves() {
+		if strings.HasPrefix(m, "48-56-") {
+			promotions++
+			restored, _ := NewGameFromFEN(g.FEN(), nil)
+			if err := restored.Promote(m); err != nil {
+				t.Error("expected", m, "to be a valid promotion but got", err)
+			}
+		}
+	}
+	if promotions != 4 {
+		t.Error("expected 4 promotions but got", g.ValidMoves())
+	}
+}
+
+func TestRestoreGameKeepsRepetitions(t *testing.T) {
+	// after the pawns the knights go back and forth, the fifth time a position
+	// comes up the game is drawn
+	knights := []string{"6-21", "62-45", "21-6", "45-62"}
+	moves := []string{"12-20", "52-44"}
+	for i := 0; i < 4; i++ {
+		moves = append(moves, knights...)
+	}
+	g := NewGame()
+	for _, m := range moves[:12] {
+		if err := g.Move(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fen, since := g.Repetitions()
+	if !reflect.DeepEqual(since, moves[2:12]) {
+		t.Error("expected the moves since the last pawn move but got", since)
+	}
+	history := g.Moves()[:len(g.Moves())-len(since)]
+	restored, err := RestoreGame(fen, since, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.FEN() != g.FEN() || !reflect.DeepEqual(restored.Moves(), g.Moves()) {
+		t.Error("expected", g.FEN(), g.Moves(), "but got", restored.FEN(), restored.Moves())
+	}
+	for _, m := range moves[12:] {
+		g.Move(m)
+		restored.Move(m)
+	}
+	if g.Status() != 3 || restored.Status() != 3 {
+		t.Error("expected the fivefold repetition to draw both games but got", g.Status(), restored.Status())
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// commands are run instead of the server when they're named after the flags,
+// as in go-chess -data data verify
+var commands = map[string]func(dataDir string, args []string) error{
+	"verify":   verifyCommand,
+	"export":   exportCommand,
+	"import":   importCommand,
+	"inspect":  inspectCommand,
+	"loadtest": loadTestCommand,
+}
+
+// runCommand runs the command in args and returns the process' exit code
+func runCommand(dataDir string, args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command", args[0])
+		flag.Usage()
+		return 2
+	}
+	if err := cmd(dataDir, args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openStore reads the event log in dataDir into a read-only store, it changes
+// nothing there so it's safe beside a running server. Without types the
+// events are read exactly as they're stored.
+// A record at the tail cut short, as an append in flight leaves it, is left out.
+func openStore(dataDir string, types *store.TypeRegistry) (*store.EventStore, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, err
+	}
+	s, err := store.NewReadOnlyFileEventStore(dataDir, types)
+	if tail, ok := err.(*store.CorruptTailError); ok {
+		fmt.Fprintln(os.Stderr, "leaving out the tail of the log:", tail)
+		return s, nil
+	}
+	return s, err
+}
+
+// verifyCommand walks the hash chain of the log and fails at the first broken
+// link. A corrupt record at the tail fails it too, it's reported and left in
+// place, as are events at the front of the log without a hash unless
+// -allow-unhashed says the log was started before it was chained.
+func verifyCommand(dataDir string, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	allowUnhashed := flags.Bool("allow-unhashed", false, "accept events at the front of the log that have no hash, as a log started before it was chained has")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dataDir); err != nil {
+		return err
+	}
+	s, err := store.NewReadOnlyFileEventStore(dataDir, nil)
+	tail, _ := err.(*store.CorruptTailError)
+	if err != nil && tail == nil {
+		return err
+	}
+	report, err := s.VerifyChain()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d events verified, %d from before the log was chained, %d archived and compacted away\n", report.Verified, report.Unhashed, report.Compacted)
+	switch {
+	case report.Broken != nil:
+		return fmt.Errorf("the log was tampered with at %v", report.Broken)
+	case tail != nil:
+		return fmt.Errorf("the log ends in a record that's cut short or tampered with, %v", tail)
+	case report.Unhashed > 0 && !*allowUnhashed:
+		return fmt.Errorf("the first %d events have no hash, they're either from before the log was chained or had their hashes stripped, -allow-unhashed accepts them", report.Unhashed)
+	}
+	return nil
+}
+
+// exportCommand writes the events of the store as JSON lines, exactly as they're stored
+func exportCommand(dataDir string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	gameID := flags.String("game", "", "only export the events of this game")
+	since := flags.String("since", "", "only export events from this time on (RFC 3339)")
+	until := flags.String("until", "", "only export events before this time (RFC 3339)")
+	out := flags.String("o", "-", "file to write to, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	from, err := parseTime(*since)
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(*until)
+	if err != nil {
+		return err
+	}
+
+	s, err := openStore(dataDir, nil)
+	if err != nil {
+		return err
+	}
+	events := s.Events()
+	if *gameID != "" {
+		events = s.ReadStream(*gameID, 0)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	exported := 0
+	for _, e := range events {
+		ts := e.Metadata.Timestamp
+		if (!from.IsZero() && ts.Before(from)) || (!to.IsZero() && !ts.Before(to)) {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		exported++
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d events exported\n", exported)
+	return nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// importCommand loads events written by export into an empty store, keeping their Ids
+func importCommand(dataDir string, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := flags.String("i", "-", "file to read from, - for stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	s, err := store.NewFileEventStore(dataDir, nil)
+	if err != nil {
+		return err
+	}
+	if s.LastID() >= 0 {
+		return fmt.Errorf("the store in %s isn't empty", dataDir)
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	imported := 0
+	for {
+		var e store.Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("event %d: %v", imported+1, err)
+		}
+		if err := s.Import(e); err != nil {
+			return err
+		}
+		imported++
+	}
+	fmt.Fprintf(os.Stderr, "%d events imported\n", imported)
+	return nil
+}
+
+// inspectCommand prints the events of a game with their payloads and the board they lead to
+func inspectCommand(dataDir string, args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	gameID := flags.String("game", "", "game to inspect")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *gameID == "" {
+		return fmt.Errorf("inspect needs a -game")
+	}
+	types := handlers.EventTypes()
+	s, err := openStore(dataDir, types)
+	if err != nil {
+		return err
+	}
+
+	stream := s.ReadStream(*gameID, 0)
+	if len(stream) == 0 {
+		return fmt.Errorf("there's no game %s", *gameID)
+	}
+	for _, e := range stream {
+		payload, err := types.Decode(e)
+		if err != nil {
+			payload = fmt.Sprintf("undecodable %q: %v", e.EventData, err)
+		}
+		fmt.Printf("%5d v%-3d %-20s %+v\n      %v\n", e.Id, e.Version, e.TypeName, payload, e.Metadata)
+	}
+	game := handlers.Aggregate(chess.NewGame(), handlers.FilterEvents(stream, *gameID), *gameID, -1)
+	fmt.Println()
+	fmt.Println(game.Debug())
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-chess-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	from, to, file := filepath.Join(dir, "from"), filepath.Join(dir, "to"), filepath.Join(dir, "events.jsonl")
+
+	s, err := store.NewFileEventStore(from, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedGames(s, 2, "game")
+	s.Schedule(context.Background(), "later", s.Events()[0].Metadata.Timestamp.Add(handlers.DrawOfferTimeout), store.Event{AggregateID: "game-0", EventType: handlers.EventDrawOfferExpired})
+
+	if err := exportCommand(from, []string{"-o", file}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importCommand(to, []string{"-i", file}); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := openStore(to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported.Events(), s.Events()) {
+		t.Error("expected to import", s.Events(), "but got", imported.Events())
+	}
+	if report, _ := imported.VerifyChain(); report.Broken != nil {
+		t.Error("expected the imported chain to hold but got", report.Broken)
+	}
+	if len(imported.Scheduled()) != 1 {
+		t.Error("expected the schedule to be imported but got", imported.Scheduled())
+	}
+	if err := importCommand(to, []string{"-i", file}); err == nil {
+		t.Error("expected importing into a store that isn't empty to fail")
+	}
+
+	if err := exportCommand(from, []string{"-game", "game-1", "-o", file}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(file)
+	game := filepath.Join(dir, "game")
+	if err := importCommand(game, []string{"-i", file}); err != nil {
+		t.Fatal(err)
+	}
+	imported, _ = openStore(game, nil)
+	if !reflect.DeepEqual(imported.Events(), s.ReadStream("game-1", 0)) {
+		t.Error("expected to only export game-1 but got", string(data))
+	}
+}
+
+func TestLoadTest(t *testing.T) {
+	l := &loadTest{players: 2, duration: time.Second, rollback: .2, maxMoves: 30, timeout: 5 * time.Second, seed: 1, client: http.DefaultClient}
+	stop, err := l.serve(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	report := l.run()
+	if report.Commands == 0 || report.GamesStarted == 0 || len(report.Latencies) != report.Commands {
+		t.Error("expected every command played to succeed but got", report.Commands, "commands,", len(report.Latencies), "succeeded")
+	}
+	if report.Failed != 0 || report.TimedOut != 0 {
+		t.Error("expected no command to fail but", report.Failed, "failed and", report.TimedOut, "timed out")
+	}
+}
+
+func TestVerifyFailsOnABadTailOrUnhashedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-chess-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	chained, unhashed := filepath.Join(dir, "chained"), filepath.Join(dir, "unhashed")
+
+	s, err := store.NewFileEventStore(chained, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedGames(s, 1, "game")
+	s.Shutdown(context.Background())
+	if err := verifyCommand(chained, nil); err != nil {
+		t.Fatal("expected the log to verify but got", err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(chained, "*.seg"))
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("garbage"))
+	f.Close()
+	before, _ := os.Stat(last)
+	if err := verifyCommand(chained, nil); err == nil {
+		t.Error("expected a corrupt tail to fail verification")
+	}
+	if after, _ := os.Stat(last); after.Size() != before.Size() {
+		t.Error("expected the corrupt tail to be left in place but the segment went from", before.Size(), "to", after.Size(), "bytes")
+	}
+
+	// a log with its hashes stripped looks like one from before chaining
+	l, _, err := store.OpenFileLog(unhashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(store.Event{Id: 0, AggregateID: "game", EventType: handlers.EventMoveRequest, Version: 1})
+	l.Close()
+	if err := verifyCommand(unhashed, nil); err == nil {
+		t.Error("expected events without a hash to fail verification")
+	}
+	if err := verifyCommand(unhashed, []string{"-allow-unhashed"}); err != nil {
+		t.Error("expected -allow-unhashed to accept events without a hash but got", err)
+	}
+}
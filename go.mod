@@ -4,6 +4,5 @@ go 1.13
 
 require (
 	github.com/notnil/chess v0.0.0-20191006020310-e7f43cbaaded
-	github.com/ronna-s/go-chess v0.0.0-20191031005751-b8209d3eb472
 	golang.org/x/net v0.0.0-20191028085509-fe3aa8a45271
 )
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+// ArchivedGame is what's kept of a game that ended once its events are
+// compacted away, it's the Summary of the game's store.Archive
+type ArchivedGame struct {
+	Moves []ArchivedMove
+	// RolledBack are the moves that were taken back and Rollbacks the Ids of
+	// the events that took them back, so the game can be summed up as it was
+	// before they were
+	RolledBack []ArchivedMove `json:",omitempty"`
+	Rollbacks  []int          `json:",omitempty"`
+	// Result is the type of the event that ended the game
+	Result  int
+	Started time.Time
+	Ended   time.Time
+	// Events is how many events the game had
+	Events int
+}
+
+// ArchivedMove is a move or promotion, Move is in the form the board sends
+// it and Id is the Id of its event
+type ArchivedMove struct {
+	Id        int
+	Move      string
+	Promotion bool `json:",omitempty"`
+}
+
+// ArchiveGame sums up a game from its stream, false if the game didn't end
+// or was played on or rolled back after it did
+func ArchiveGame(stream []store.Event) (ArchivedGame, bool) {
+	var a ArchivedGame
+	if len(stream) == 0 {
+		return a, false
+	}
+	for _, e := range stream {
+		switch e.EventType {
+		case EventWhiteWins, EventBlackWins, EventDraw:
+			a.Result = e.EventType
+		case EventMoveSuccess, EventPromotionSuccess:
+			m, err := archivedMove(e)
+			if err != nil {
+				return a, false
+			}
+			a.Moves = append(a.Moves, m)
+			// the game went on after a result, it's over only if another one came
+			a.Result = 0
+		case EventRollbackSuccess:
+			if len(a.Moves) > 0 {
+				a.RolledBack = append(a.RolledBack, a.Moves[len(a.Moves)-1])
+				a.Moves = a.Moves[:len(a.Moves)-1]
+			}
+			a.Rollbacks = append(a.Rollbacks, e.Id)
+			a.Result = 0
+		}
+	}
+	if a.Result == 0 {
+		return a, false
+	}
+	a.Started = stream[0].Metadata.Timestamp
+	a.Ended = stream[len(stream)-1].Metadata.Timestamp
+	a.Events = len(stream)
+	return a, true
+}
+
+func archivedMove(e store.Event) (ArchivedMove, error) {
+	m := ArchivedMove{Id: e.Id, Promotion: e.EventType == EventPromotionSuccess}
+	if m.Promotion {
+		var p PromotionPayload
+		if err := DecodePayload(e, &p); err != nil {
+			return m, err
+		}
+		m.Move = p.Query()
+	} else {
+		var p MovePayload
+		if err := DecodePayload(e, &p); err != nil {
+			return m, err
+		}
+		m.Move = p.Query()
+	}
+	return m, nil
+}
+
+// DecodeArchivedGame reads the summary of an archived game. When the archive
+// was cut down to part of the game's events, as store.AsOf does, the summary
+// is too: it has the moves played by the last of them and the game hadn't
+// ended yet. Archives from before rolled back moves were kept lose the moves
+// rolled back after the cut.
+func DecodeArchivedGame(a store.Archive) (ArchivedGame, error) {
+	var g ArchivedGame
+	if err := json.Unmarshal(a.Summary, &g); err != nil {
+		return g, err
+	}
+	if len(a.EventIDs) >= g.Events {
+		return g, nil
+	}
+	ids := make(map[int]bool, len(a.EventIDs))
+	for _, id := range a.EventIDs {
+		ids[id] = true
+	}
+	// the moves and rollbacks among the events, played again in order
+	var played []ArchivedMove
+	for _, m := range append(append([]ArchivedMove(nil), g.Moves...), g.RolledBack...) {
+		if ids[m.Id] {
+			played = append(played, m)
+		}
+	}
+	rollbacks := map[int]bool{}
+	for _, id := range g.Rollbacks {
+		if ids[id] {
+			played = append(played, ArchivedMove{Id: id})
+			rollbacks[id] = true
+		}
+	}
+	sort.Slice(played, func(i, j int) bool {
+		return played[i].Id < played[j].Id
+	})
+	moves := []ArchivedMove{}
+	for _, m := range played {
+		switch {
+		case !rollbacks[m.Id]:
+			moves = append(moves, m)
+		case len(moves) > 0:
+			moves = moves[:len(moves)-1]
+		}
+	}
+	g.Moves, g.Result, g.Ended, g.Events = moves, 0, time.Time{}, len(a.EventIDs)
+	g.RolledBack, g.Rollbacks = nil, nil
+	return g, nil
+}
+
+// GameMoves picks the moves of a game out of a log like FilterEvents does,
+// from the game's archive if its events were compacted away
+func GameMoves(events []store.Event, gameID string) []store.Event {
+	for _, e := range events {
+		a, ok := store.ArchiveOf(e)
+		if !ok || a.AggregateID != gameID {
+			continue
+		}
+		game, err := DecodeArchivedGame(a)
+		if err != nil {
+			log.Println("skipping unreadable archive", e, err)
+			continue
+		}
+		return game.MoveEvents(gameID)
+	}
+	return FilterEvents(events, gameID)
+}
+
+// MoveEvents returns the events the game's moves were played with,
+// as FilterEvents picks them out of the game's stream
+func (a ArchivedGame) MoveEvents(gameID string) []store.Event {
+	events := make([]store.Event, 0, len(a.Moves))
+	for _, m := range a.Moves {
+		e := store.Event{Id: m.Id, AggregateID: gameID, EventType: EventMoveSuccess}
+		if m.Promotion {
+			e.EventType = EventPromotionSuccess
+			p, _ := ParsePromotion(m.Move)
+			e.EventData = store.EncodePayload(p)
+		} else {
+			p, _ := ParseMove(m.Move)
+			e.EventData = store.EncodePayload(p)
+		}
+		events = append(events, e)
+	}
+	return events
+}
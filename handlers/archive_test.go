@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+func TestArchivedGameBeforeARollback(t *testing.T) {
+	move := func(id int, query string) store.Event {
+		p, _ := ParseMove(query)
+		return store.Event{Id: id, AggregateID: "over", EventType: EventMoveSuccess, EventData: store.EncodePayload(p)}
+	}
+	stream := []store.Event{
+		move(0, "12-28"),
+		move(1, "52-36"),
+		{Id: 2, AggregateID: "over", EventType: EventRollbackSuccess},
+		move(3, "51-35"),
+		{Id: 4, AggregateID: "over", EventType: EventDraw},
+	}
+	game, ok := ArchiveGame(stream)
+	if !ok {
+		t.Fatal("expected the game to be over")
+	}
+	summary := store.EncodePayload(game)
+
+	for _, c := range []struct {
+		ids   []int
+		moves []string
+	}{
+		{[]int{0, 1}, []string{"12-28", "52-36"}},
+		{[]int{0, 1, 2}, []string{"12-28"}},
+		{[]int{0, 1, 2, 3}, []string{"12-28", "51-35"}},
+		{[]int{0, 1, 2, 3, 4}, []string{"12-28", "51-35"}},
+	} {
+		g, err := DecodeArchivedGame(store.Archive{AggregateID: "over", EventIDs: c.ids, Summary: []byte(summary)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var moves []string
+		for _, m := range g.Moves {
+			moves = append(moves, m.Move)
+		}
+		if !reflect.DeepEqual(moves, c.moves) {
+			t.Error("expected the moves", c.moves, "by event", c.ids[len(c.ids)-1], "but got", moves)
+		}
+		if over := len(c.ids) == len(stream); (g.Result != 0) != over {
+			t.Error("expected the game to be over only by its last event but got", g.Result, "by event", c.ids[len(c.ids)-1])
+		}
+	}
+}
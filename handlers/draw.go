@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+// DrawOfferTimeout is how long an offered draw stands before it expires
+const DrawOfferTimeout = time.Minute
+
+// DrawOffers is the process manager of draw offers. A player offers a draw
+// and the other player accepts or declines it before it expires, a game has
+// one offer standing at most. An offer standing when the game ends is dropped.
+func DrawOffers() *store.ProcessManager {
+	return &store.ProcessManager{
+		Name: "DrawOffers",
+		Filter: store.Filter{EventTypes: []int{
+			EventDrawOffered, EventDrawAccepted, EventDrawDeclined, EventDrawOfferExpired,
+			EventWhiteWins, EventBlackWins, EventDraw,
+		}},
+		Correlate: func(e store.Event) string {
+			return e.AggregateID
+		},
+		Start: func(e store.Event) store.Process {
+			if e.EventType != EventDrawOffered {
+				return nil
+			}
+			return &drawOffer{}
+		},
+	}
+}
+
+func drawOfferKey(gameID string) string {
+	return "draw offer " + gameID
+}
+
+type drawOffer struct {
+	offeredBy string
+	done      bool
+}
+
+func (d *drawOffer) Handle(e store.Event, cmds *store.Commands) {
+	switch e.EventType {
+	case EventDrawOffered:
+		// offering again restarts the clock
+		d.offeredBy = e.Metadata.Actor
+		cmds.Schedule(drawOfferKey(e.AggregateID), e.Metadata.Timestamp.Add(DrawOfferTimeout), store.Event{
+			AggregateID: e.AggregateID,
+			EventType:   EventDrawOfferExpired,
+			EventData:   store.EncodePayload(DrawOfferPayload{}),
+		})
+	case EventDrawAccepted:
+		// a player can't accept their own offer, and one whose session is
+		// unknown can't be told apart from the player who made it
+		if e.Metadata.Actor == "" || e.Metadata.Actor == d.offeredBy {
+			return
+		}
+		d.done = true
+		cmds.Cancel(drawOfferKey(e.AggregateID))
+		cmds.Persist(store.Event{
+			AggregateID: e.AggregateID,
+			EventType:   EventDraw,
+			EventData:   store.EncodePayload(GameOverPayload{Status: 3}),
+		})
+	case EventDrawDeclined:
+		d.done = true
+		cmds.Cancel(drawOfferKey(e.AggregateID))
+	case EventDrawOfferExpired:
+		d.done = true
+	case EventWhiteWins, EventBlackWins, EventDraw:
+		// the game ended while the offer stood, there's nothing left to accept
+		d.done = true
+		cmds.Cancel(drawOfferKey(e.AggregateID))
+	}
+}
+
+func (d *drawOffer) Done() bool {
+	return d.done
+}
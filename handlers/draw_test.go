@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+func drawEvent(eventType int, actor string, at time.Time) store.Event {
+	return store.Event{
+		AggregateID: "some game",
+		EventType:   eventType,
+		Metadata:    store.Metadata{Actor: actor, Timestamp: at},
+	}
+}
+
+func TestDrawOfferAccepted(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pm := DrawOffers()
+	offer := drawEvent(EventDrawOffered, "alice", now)
+	p := pm.Start(offer)
+
+	var cmds store.Commands
+	p.Handle(offer, &cmds)
+	schedules := cmds.Schedules()
+	if len(schedules) != 1 || !schedules[0].At.Equal(now.Add(DrawOfferTimeout)) || schedules[0].Event.EventType != EventDrawOfferExpired {
+		t.Fatal("expected the offer to expire after", DrawOfferTimeout, "but got", schedules)
+	}
+
+	cmds = store.Commands{}
+	p.Handle(drawEvent(EventDrawAccepted, "alice", now), &cmds)
+	if p.Done() || len(cmds.Events()) != 0 {
+		t.Fatal("expected a player not to be able to accept their own offer but got", cmds.Events())
+	}
+
+	cmds = store.Commands{}
+	p.Handle(drawEvent(EventDrawAccepted, "", now), &cmds)
+	if p.Done() || len(cmds.Events()) != 0 {
+		t.Fatal("expected an accept without an actor to be ignored but got", cmds.Events())
+	}
+
+	cmds = store.Commands{}
+	p.Handle(drawEvent(EventDrawAccepted, "bob", now), &cmds)
+	draw := []store.Event{{AggregateID: "some game", EventType: EventDraw, EventData: store.EncodePayload(GameOverPayload{Status: 3})}}
+	if !p.Done() || !reflect.DeepEqual(cmds.Events(), draw) || !reflect.DeepEqual(cmds.Cancels(), []string{schedules[0].Key}) {
+		t.Error("expected the game to end in a draw and the expiry to be cancelled but got", cmds.Events(), cmds.Cancels())
+	}
+}
+
+func TestDrawOfferEnds(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pm := DrawOffers()
+	if pm.Start(drawEvent(EventDrawAccepted, "bob", now)) != nil {
+		t.Error("expected only an offer to start a process")
+	}
+	for _, end := range []int{EventDrawDeclined, EventDrawOfferExpired} {
+		offer := drawEvent(EventDrawOffered, "alice", now)
+		p := pm.Start(offer)
+		p.Handle(offer, &store.Commands{})
+		var cmds store.Commands
+		p.Handle(drawEvent(end, "bob", now), &cmds)
+		if !p.Done() || len(cmds.Events()) != 0 {
+			t.Error("expected event type", end, "to end the offer without a draw but got", cmds.Events())
+		}
+	}
+}
+
+func TestDrawOfferDroppedWhenTheGameEnds(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pm := DrawOffers()
+	if pm.Start(drawEvent(EventBlackWins, "", now)) != nil {
+		t.Error("expected a result not to start a process")
+	}
+	offer := drawEvent(EventDrawOffered, "alice", now)
+	p := pm.Start(offer)
+	var cmds store.Commands
+	p.Handle(offer, &cmds)
+	key := cmds.Schedules()[0].Key
+
+	cmds = store.Commands{}
+	p.Handle(drawEvent(EventBlackWins, "bob", now), &cmds)
+	if !p.Done() || len(cmds.Events()) != 0 || !reflect.DeepEqual(cmds.Cancels(), []string{key}) {
+		t.Error("expected the mate to drop the offer and its expiry but got", cmds.Events(), cmds.Cancels())
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+// Event types are stored with every event, so a value must never change or be
+// reused once it was released. Stores with the registry from EventTypes also
+// keep the name of the type with the event and go by it when reading.
+const (
+	EventMoveRequest      = 1
+	EventMoveSuccess      = 2
+	EventMoveFail         = 3
+	EventPromotionRequest = 4
+	EventPromotionSuccess = 5
+	EventPromotionFail    = 6
+	EventWhiteWins        = 7
+	EventBlackWins        = 8
+	EventDraw             = 9
+	EventRollbackRequest  = 10
+	EventRollbackSuccess  = 11
+	EventDrawOffered      = 12
+	EventDrawAccepted     = 13
+	EventDrawDeclined     = 14
+	EventDrawOfferExpired = 15
+)
+
+// payloadVersion is the schema version of every payload below.
+// Version 1 was the bare strings events carried before they had payloads.
+const payloadVersion = 2
+
+// MovePayload is the data of move requests and successes
+type MovePayload struct {
+	From int
+	To   int
+}
+
+// Query returns the move the way Game.Move takes it
+func (p MovePayload) Query() string {
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+// ParseMove reads a move in the "from-to" form the board sends
+func ParseMove(query string) (MovePayload, error) {
+	var p MovePayload
+	if _, err := fmt.Sscanf(query, "%d-%d", &p.From, &p.To); err != nil {
+		return p, fmt.Errorf("invalid move %q: %v", query, err)
+	}
+	return p, nil
+}
+
+// PromotionPayload is the data of promotion requests and successes
+type PromotionPayload struct {
+	From  int
+	To    int
+	Piece string
+}
+
+// Query returns the promotion the way Game.Promote takes it
+func (p PromotionPayload) Query() string {
+	return fmt.Sprintf("%d-%d-%s", p.From, p.To, p.Piece)
+}
+
+// ParsePromotion reads a promotion in the "from-to-piece" form the board sends
+func ParsePromotion(query string) (PromotionPayload, error) {
+	var p PromotionPayload
+	if _, err := fmt.Sscanf(query, "%d-%d-%s", &p.From, &p.To, &p.Piece); err != nil {
+		return p, fmt.Errorf("invalid promotion %q: %v", query, err)
+	}
+	return p, nil
+}
+
+// FailurePayload is the data of failed moves and promotions
+type FailurePayload struct {
+	Reason string
+}
+
+// GameOverPayload is the data of the events ending a game, Status is the game's Status()
+type GameOverPayload struct {
+	Status int
+}
+
+// RollbackPayload is the data of rollback requests and successes
+type RollbackPayload struct{}
+
+// DrawOfferPayload is the data of the events of a draw offer,
+// who offered and who answered is the Actor of the events
+type DrawOfferPayload struct{}
+
+// DecodePayload decodes the data of an event into payload
+func DecodePayload(event store.Event, payload interface{}) error {
+	if err := json.Unmarshal([]byte(event.EventData), payload); err != nil {
+		return fmt.Errorf("event %d: %v", event.Id, err)
+	}
+	return nil
+}
+
+// EventTypes returns a registry of every event type the game uses
+func EventTypes() *store.TypeRegistry {
+	r := store.NewTypeRegistry()
+	register := func(eventType int, name string, payload func() interface{}, upcast store.Upcaster) {
+		r.Register(store.EventType{
+			Type:      eventType,
+			Name:      name,
+			Version:   payloadVersion,
+			New:       payload,
+			Upcasters: map[int]store.Upcaster{1: upcast},
+		})
+	}
+	move := func() interface{} { return &MovePayload{} }
+	promotion := func() interface{} { return &PromotionPayload{} }
+	failure := func() interface{} { return &FailurePayload{} }
+	gameOver := func() interface{} { return &GameOverPayload{} }
+	rollback := func() interface{} { return &RollbackPayload{} }
+	drawOffer := func() interface{} { return &DrawOfferPayload{} }
+
+	register(EventMoveRequest, "MoveRequested", move, upcastMove)
+	register(EventMoveSuccess, "MoveSucceeded", move, upcastMove)
+	register(EventMoveFail, "MoveFailed", failure, upcastFailure)
+	register(EventPromotionRequest, "PromotionRequested", promotion, upcastPromotion)
+	register(EventPromotionSuccess, "PromotionSucceeded", promotion, upcastPromotion)
+	register(EventPromotionFail, "PromotionFailed", failure, upcastFailure)
+	register(EventWhiteWins, "WhiteWon", gameOver, upcastGameOver(1))
+	register(EventBlackWins, "BlackWon", gameOver, upcastGameOver(2))
+	register(EventDraw, "Drawn", gameOver, upcastGameOver(3))
+	register(EventRollbackRequest, "RollbackRequested", rollback, upcastRollback)
+	register(EventRollbackSuccess, "RollbackSucceeded", rollback, upcastRollback)
+
+	// draw offers came after version 1, there's nothing to upcast
+	for _, t := range []struct {
+		eventType int
+		name      string
+	}{
+		{EventDrawOffered, "DrawOffered"},
+		{EventDrawAccepted, "DrawAccepted"},
+		{EventDrawDeclined, "DrawDeclined"},
+		{EventDrawOfferExpired, "DrawOfferExpired"},
+	} {
+		r.Register(store.EventType{Type: t.eventType, Name: t.name, Version: payloadVersion, New: drawOffer,
+			Upcasters: map[int]store.Upcaster{1: upcastDrawOffer}})
+	}
+	return r
+}
+
+func upcastMove(data string) (string, error) {
+	p, err := ParseMove(data)
+	if err != nil {
+		return data, err
+	}
+	return store.EncodePayload(p), nil
+}
+
+func upcastPromotion(data string) (string, error) {
+	p, err := ParsePromotion(data)
+	if err != nil {
+		return data, err
+	}
+	return store.EncodePayload(p), nil
+}
+
+// version 1 failures held the error text
+func upcastFailure(data string) (string, error) {
+	return store.EncodePayload(FailurePayload{Reason: data}), nil
+}
+
+// version 1 game endings held the move that ended the game, the type says all there is to know
+func upcastGameOver(status int) store.Upcaster {
+	return func(string) (string, error) {
+		return store.EncodePayload(GameOverPayload{Status: status}), nil
+	}
+}
+
+func upcastDrawOffer(string) (string, error) {
+	return store.EncodePayload(DrawOfferPayload{}), nil
+}
+
+func upcastRollback(string) (string, error) {
+	return store.EncodePayload(RollbackPayload{}), nil
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+func TestEventTypesUpcastLegacyEvents(t *testing.T) {
+	types := EventTypes()
+	testCases := []struct {
+		event    store.Event
+		expected interface{}
+	}{
+		{store.Event{EventType: EventMoveRequest, EventData: "12-20"}, &MovePayload{From: 12, To: 20}},
+		{store.Event{EventType: EventPromotionSuccess, EventData: "51-59-q"}, &PromotionPayload{From: 51, To: 59, Piece: "q"}},
+		{store.Event{EventType: EventMoveFail, EventData: "invalid move"}, &FailurePayload{Reason: "invalid move"}},
+		{store.Event{EventType: EventBlackWins, EventData: "52-36"}, &GameOverPayload{Status: 2}},
+		{store.Event{EventType: EventRollbackSuccess}, &RollbackPayload{}},
+	}
+	for _, c := range testCases {
+		ev, err := types.Upcast(c.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := types.Decode(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(payload, c.expected) {
+			t.Error("expected", c.event, "to upcast to", c.expected, "but got", payload)
+		}
+	}
+}
@@ -1,23 +1,10 @@
 package handlers
 
 import (
-	"github.com/ronna-s/go-chess/chess"
-	"github.com/ronna-s/go-chess/store"
-)
+	"log"
 
-const (
-	_                     = iota
-	EventMoveRequest
-	EventMoveSuccess
-	EventMoveFail
-	EventPromotionRequest
-	EventPromotionSuccess
-	EventPromotionFail
-	EventWhiteWins
-	EventBlackWins
-	EventDraw
-	EventRollbackRequest
-	EventRollbackSuccess
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/store"
 )
 
 type Game interface {
@@ -27,46 +14,136 @@ type Game interface {
 	Status() int
 	Draw() [][]chess.Square
 	Debug() string
+	FEN() string
 	ValidPromotions(query string) (pieces []chess.Piece)
 }
 
+// EventPersister is handed to handlers already bound to the version of the game
+// they were given, so Persist fails with a *store.ConflictError if the game
+// moved on in the meantime (see store.VersionedPersister)
 type EventPersister interface {
-	Persist(event store.Event)
+	Persist(event store.Event) error
 }
 
-// MoveHandler should listen on events of type EventMoveRequest (and ignore all others)
-// It will check if possible to perform the move and persist a new EventMoveSuccess if success
-// otherwise it will persist EventMoveFail
-// look at store/event.go to see how events are defined
-func MoveHandler(game Game, event store.Event, eventStore EventPersister) {
+// MoveHandler listens on events of type EventMoveRequest (and ignores all others)
+// It checks if possible to perform the move and persists a new EventMoveSuccess if success
+// otherwise it persists EventMoveFail
+func MoveHandler(game Game, event store.Event, eventStore EventPersister) error {
+	if event.EventType != EventMoveRequest {
+		return nil
+	}
+	ev := store.Event{
+		AggregateID: event.AggregateID,
+		EventData:   event.EventData,
+		EventType:   EventMoveSuccess,
+		Metadata:    store.CausedBy(event),
+	}
+	var p MovePayload
+	err := DecodePayload(event, &p)
+	if err == nil {
+		err = game.Move(p.Query())
+	}
+	if err != nil {
+		ev.EventData = store.EncodePayload(FailurePayload{Reason: err.Error()})
+		ev.EventType = EventMoveFail
+	}
+	return eventStore.Persist(ev)
 }
 
-// PromotionHandler should listen on events of type EventPromotionRequest
-// It will check if possible to perform the promotion
-// and persist a new EventPromotionSuccess if success,
-// otherwise it will persist EventPromotionFail
-func PromotionHandler(game Game, event store.Event, eventStore EventPersister) {
+// PromotionHandler listens on events of type EventPromotionRequest
+// It checks if possible to perform the promotion
+// and persists a new EventPromotionSuccess if success,
+// otherwise it persists EventPromotionFail
+func PromotionHandler(game Game, event store.Event, eventStore EventPersister) error {
+	if event.EventType != EventPromotionRequest {
+		return nil
+	}
+	ev := store.Event{
+		AggregateID: event.AggregateID,
+		EventData:   event.EventData,
+		EventType:   EventPromotionSuccess,
+		Metadata:    store.CausedBy(event),
+	}
+	var p PromotionPayload
+	err := DecodePayload(event, &p)
+	if err == nil {
+		err = game.Promote(p.Query())
+	}
+	if err != nil {
+		ev.EventData = store.EncodePayload(FailurePayload{Reason: err.Error()})
+		ev.EventType = EventPromotionFail
+	}
+	return eventStore.Persist(ev)
 }
 
-// Rollback handler should listen on events of type EventRollbackRequest
-// and persist a new EventRollbackSuccess to the store (rollback cannot fail)
-func RollbackHandler(_ Game, event store.Event, eventStore EventPersister) {
+// Rollback handler listens on events of type EventRollbackRequest
+// and persists a new EventRollbackSuccess to the store (rollback cannot fail)
+func RollbackHandler(_ Game, event store.Event, eventStore EventPersister) error {
+	if event.EventType != EventRollbackRequest {
+		return nil
+	}
+	return eventStore.Persist(store.Event{
+		AggregateID: event.AggregateID,
+		EventData:   store.EncodePayload(RollbackPayload{}),
+		EventType:   EventRollbackSuccess,
+		Metadata:    store.CausedBy(event),
+	})
 }
 
-// FilterEvents is a function that receives an events slice and returns a new
+// FilterEvents receives an events slice and returns a new
 // slice after filtering out:
 // 1. events that do not belong to the gameID (AggregateID field)
 // 2. events that are not of action types (move, promotion)
 // 3. events that have been rolled back
 func FilterEvents(events []store.Event, gameID string) []store.Event {
-	return events
+	filtered := []store.Event{}
+	for _, event := range events {
+		if event.AggregateID != gameID {
+			continue
+		}
+		switch event.EventType {
+		case EventMoveSuccess, EventPromotionSuccess:
+			filtered = append(filtered, event)
+		case EventRollbackSuccess:
+			if len(filtered) > 0 {
+				filtered = filtered[:len(filtered)-1]
+			}
+		}
+	}
+	return filtered
 }
 
-// Aggregate should receive a game, an events slice, gameID and movesCount
+// Aggregate receives a game, an events slice, gameID and movesCount
 // and returns the game after applying the events to it:
-// iterate over the events and perform actions (Move, Promote) when appropriate
-// stop when you have reached the moves count
-// You can assume moveCount will be -1 to perform all actions
+// it iterates over the events and performs actions (Move, Promote) when appropriate
+// and stops when it has reached the moves count (-1 performs all actions)
 func Aggregate(game Game, events []store.Event, gameID string, movesCount int) Game {
+	moves := 0
+	for _, event := range events {
+		if movesCount >= 0 && moves >= movesCount {
+			break
+		}
+		if event.AggregateID != gameID {
+			continue
+		}
+		switch event.EventType {
+		case EventMoveSuccess:
+			var p MovePayload
+			if err := DecodePayload(event, &p); err != nil {
+				log.Println("skipping move:", err)
+				continue
+			}
+			game.Move(p.Query())
+			moves++
+		case EventPromotionSuccess:
+			var p PromotionPayload
+			if err := DecodePayload(event, &p); err != nil {
+				log.Println("skipping promotion:", err)
+				continue
+			}
+			game.Promote(p.Query())
+			moves++
+		}
+	}
 	return game
 }
@@ -7,7 +7,7 @@ import (
 
 	"errors"
 
-	"github.com/ronna-s/go-chess/store"
+	"github.com/scottcarol/go-chess/store"
 )
 
 type FakeGame struct {
@@ -34,8 +34,34 @@ type FakeStore struct {
 	persistFn func(store.Event)
 }
 
-func (s FakeStore) Persist(event store.Event) {
+func (s FakeStore) Persist(event store.Event) error {
 	s.persistFn(event)
+	return nil
+}
+
+func moveData(query string) string {
+	p, err := ParseMove(query)
+	if err != nil {
+		panic(err)
+	}
+	return store.EncodePayload(p)
+}
+
+func promotionData(query string) string {
+	p, err := ParsePromotion(query)
+	if err != nil {
+		panic(err)
+	}
+	return store.EncodePayload(p)
+}
+
+func failureData(reason string) string {
+	return store.EncodePayload(FailurePayload{Reason: reason})
+}
+
+// causedBy is the metadata handlers give the events they persist in response to event id
+func causedBy(id int) store.Metadata {
+	return store.Metadata{CausationID: &id}
 }
 
 func TestMoveHandlerBasic(t *testing.T) {
@@ -55,14 +81,14 @@ func TestMoveHandlerBasic(t *testing.T) {
 		expectedToPersist []store.Event
 	}{
 		events: []store.Event{
-			{EventType: EventMoveRequest, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
-			{EventType: EventPromotionRequest, EventData: "move2", AggregateID: "other game"},
-			{EventType: EventMoveRequest, EventData: "move2", AggregateID: "other game"},
+			{Id: 0, EventType: EventMoveRequest, EventData: moveData("12-20"), AggregateID: "some game"},
+			{Id: 1, EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
+			{Id: 2, EventType: EventPromotionRequest, EventData: promotionData("52-36-q"), AggregateID: "other game"},
+			{Id: 3, EventType: EventMoveRequest, EventData: moveData("52-36"), AggregateID: "other game"},
 		},
 		expectedToPersist: []store.Event{
-			{EventType: EventMoveSuccess, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventMoveSuccess, EventData: "move2", AggregateID: "other game"},
+			{EventType: EventMoveSuccess, EventData: moveData("12-20"), AggregateID: "some game", Metadata: causedBy(0)},
+			{EventType: EventMoveSuccess, EventData: moveData("52-36"), AggregateID: "other game", Metadata: causedBy(3)},
 		},
 	}
 
@@ -91,14 +117,14 @@ func TestMoveHandlerError(t *testing.T) {
 		expectedToPersist []store.Event
 	}{
 		events: []store.Event{
-			{EventType: EventMoveRequest, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventMoveRequest, EventData: "move2", AggregateID: "other game"},
-			{EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
-			{EventType: EventPromotionRequest, EventData: "move2", AggregateID: "other game"},
+			{Id: 0, EventType: EventMoveRequest, EventData: moveData("12-20"), AggregateID: "some game"},
+			{Id: 1, EventType: EventMoveRequest, EventData: moveData("52-36"), AggregateID: "other game"},
+			{Id: 2, EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
+			{Id: 3, EventType: EventPromotionRequest, EventData: promotionData("52-36-q"), AggregateID: "other game"},
 		},
 		expectedToPersist: []store.Event{
-			{EventType: EventMoveFail, EventData: "some error", AggregateID: "some game"},
-			{EventType: EventMoveFail, EventData: "some error", AggregateID: "other game"},
+			{EventType: EventMoveFail, EventData: failureData("some error"), AggregateID: "some game", Metadata: causedBy(0)},
+			{EventType: EventMoveFail, EventData: failureData("some error"), AggregateID: "other game", Metadata: causedBy(1)},
 		},
 	}
 
@@ -173,14 +199,14 @@ func TestPromotionHandlerBasic(t *testing.T) {
 		expectedToPersist []store.Event
 	}{
 		events: []store.Event{
-			{EventType: EventMoveRequest, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
-			{EventType: EventPromotionRequest, EventData: "move2", AggregateID: "other game"},
-			{EventType: EventMoveRequest, EventData: "move2", AggregateID: "other game"},
+			{Id: 0, EventType: EventMoveRequest, EventData: moveData("12-20"), AggregateID: "some game"},
+			{Id: 1, EventType: EventMoveSuccess, EventData: "should ignore", AggregateID: "other game"},
+			{Id: 2, EventType: EventPromotionRequest, EventData: promotionData("52-36-q"), AggregateID: "other game"},
+			{Id: 3, EventType: EventMoveRequest, EventData: moveData("52-36"), AggregateID: "other game"},
 		},
 		expectedToPersist: []store.Event{
-			{EventType: EventMoveSuccess, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventMoveSuccess, EventData: "move2", AggregateID: "other game"},
+			{EventType: EventMoveSuccess, EventData: moveData("12-20"), AggregateID: "some game", Metadata: causedBy(0)},
+			{EventType: EventMoveSuccess, EventData: moveData("52-36"), AggregateID: "other game", Metadata: causedBy(3)},
 		},
 	}
 
@@ -210,14 +236,14 @@ func TestPromotionHandlerError(t *testing.T) {
 		expectedToPersist []store.Event
 	}{
 		events: []store.Event{
-			{EventType: EventPromotionRequest, EventData: "move1", AggregateID: "some game"},
-			{EventType: EventPromotionRequest, EventData: "move2", AggregateID: "other game"},
-			{EventType: EventPromotionSuccess, EventData: "should ignore", AggregateID: "other game"},
-			{EventType: EventMoveRequest, EventData: "move2", AggregateID: "other game"},
+			{Id: 0, EventType: EventPromotionRequest, EventData: promotionData("12-20-q"), AggregateID: "some game"},
+			{Id: 1, EventType: EventPromotionRequest, EventData: promotionData("52-36-q"), AggregateID: "other game"},
+			{Id: 2, EventType: EventPromotionSuccess, EventData: "should ignore", AggregateID: "other game"},
+			{Id: 3, EventType: EventMoveRequest, EventData: moveData("52-36"), AggregateID: "other game"},
 		},
 		expectedToPersist: []store.Event{
-			{EventType: EventPromotionFail, EventData: "some error", AggregateID: "some game"},
-			{EventType: EventPromotionFail, EventData: "some error", AggregateID: "other game"},
+			{EventType: EventPromotionFail, EventData: failureData("some error"), AggregateID: "some game", Metadata: causedBy(0)},
+			{EventType: EventPromotionFail, EventData: failureData("some error"), AggregateID: "other game", Metadata: causedBy(1)},
 		},
 	}
 
@@ -248,14 +274,14 @@ func TestRollbackHandler(t *testing.T) {
 		expectedToPersist []store.Event
 	}{
 		events: []store.Event{
-			{EventType: EventRollbackRequest, AggregateID: "some game"},
-			{EventType: EventPromotionSuccess, AggregateID: "other game"},
-			{EventType: EventMoveRequest, AggregateID: "other game"},
-			{EventType: EventRollbackRequest, AggregateID: "other game"},
+			{Id: 0, EventType: EventRollbackRequest, AggregateID: "some game"},
+			{Id: 1, EventType: EventPromotionSuccess, AggregateID: "other game"},
+			{Id: 2, EventType: EventMoveRequest, AggregateID: "other game"},
+			{Id: 3, EventType: EventRollbackRequest, AggregateID: "other game"},
 		},
 		expectedToPersist: []store.Event{
-			{EventType: EventRollbackSuccess, AggregateID: "some game"},
-			{EventType: EventRollbackSuccess, AggregateID: "other game"},
+			{EventType: EventRollbackSuccess, EventData: store.EncodePayload(RollbackPayload{}), AggregateID: "some game", Metadata: causedBy(0)},
+			{EventType: EventRollbackSuccess, EventData: store.EncodePayload(RollbackPayload{}), AggregateID: "other game", Metadata: causedBy(3)},
 		},
 	}
 
@@ -310,12 +336,12 @@ func TestRebuildGameMoveEvents(t *testing.T) {
 		expectedMoves []string
 	}{
 		events: []store.Event{
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Hey"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "I'm moving"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Well done!"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "All done!"},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("12-20")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("52-36")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("6-21")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("57-42")},
 		},
-		expectedMoves: []string{"Hey", "I'm moving", "Well done!", "All done!"},
+		expectedMoves: []string{"12-20", "52-36", "6-21", "57-42"},
 	}
 	if res := Aggregate(game, testCases.events, myGameID, -1); res == nil || res.(*FakeGame) != game {
 		t.Error("Return value incorrect")
@@ -350,12 +376,12 @@ func TestRebuildGameMovePromotionEvents(t *testing.T) {
 		expectedMoves []string
 	}{
 		events: []store.Event{
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Hey"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "I'm moving"},
-			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: "I promote"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Well done!"},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("12-20")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("52-36")},
+			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: promotionData("11-3-q")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("6-21")},
 		},
-		expectedMoves: []string{"move: Hey", "move: I'm moving", "promotion: I promote", "move: Well done!"},
+		expectedMoves: []string{"move: 12-20", "move: 52-36", "promotion: 11-3-q", "move: 6-21"},
 	}
 
 	if res := Aggregate(game, testCases.events, myGameID, -1); res == nil || res.(*FakeGame) != game {
@@ -390,14 +416,14 @@ func TestRebuildGameMovePromotionEventsWithLastMoveID(t *testing.T) {
 		expectedMoves []string
 	}{
 		events: []store.Event{
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Hey"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "I'm moving"},
-			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: "I promote"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Well done!"},
-			{AggregateID: otherGameID, EventType: EventPromotionSuccess, EventData: "Please ignore me"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "All done!"},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("12-20")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("52-36")},
+			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: promotionData("11-3-q")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("6-21")},
+			{AggregateID: otherGameID, EventType: EventPromotionSuccess, EventData: promotionData("51-59-q")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("57-42")},
 		},
-		expectedMoves: []string{"move: Hey", "move: I'm moving", "promotion: I promote"},
+		expectedMoves: []string{"move: 12-20", "move: 52-36", "promotion: 11-3-q"},
 	}
 
 	if res := Aggregate(game, testCases.events, myGameID, 3); res == nil || res.(*FakeGame) != game {
@@ -429,12 +455,12 @@ func TestRebuildGameMovePromotionEventsWithRollback(t *testing.T) {
 		expectedMoves []string
 	}{
 		events: []store.Event{
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "Hey"},
-			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: "I'm moving"},
-			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: "I promote"},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("12-20")},
+			{AggregateID: myGameID, EventType: EventMoveSuccess, EventData: moveData("52-36")},
+			{AggregateID: myGameID, EventType: EventPromotionSuccess, EventData: promotionData("11-3-q")},
 			{AggregateID: myGameID, EventType: EventRollbackSuccess},
 		},
-		expectedMoves: []string{"move: Hey", "move: I'm moving", "promotion: I promote"},
+		expectedMoves: []string{"move: 12-20", "move: 52-36", "promotion: 11-3-q"},
 	}
 
 	if res := Aggregate(game, testCases.events, myGameID, -1); res == nil || res.(*FakeGame) != game {
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// SnapshotInterval is how many moves AggregateFromSnapshot replays before it takes a new snapshot
+const SnapshotInterval = 20
+
+type SnapshotStore interface {
+	Snapshots(aggregateID string) []store.Snapshot
+	SaveSnapshot(s store.Snapshot)
+	DropSnapshots(aggregateID string, moves int)
+}
+
+// AggregateFromSnapshot rebuilds a chess game like Aggregate does from the
+// output of FilterEvents, but starts from the latest snapshot that isn't past
+// movesCount instead of from move one.
+// A snapshot is only used if the event holding its last move is still in place,
+// snapshots whose moves have since been rolled back are dropped.
+// The moves since the last capture or pawn move are replayed on top of a
+// snapshot, so repetitions count the positions before it too.
+func AggregateFromSnapshot(snapshots SnapshotStore, events []store.Event, gameID string, movesCount int) Game {
+	target := len(events)
+	if movesCount >= 0 && movesCount < target {
+		target = movesCount
+	}
+
+	var (
+		game = chess.NewGame()
+		from int
+	)
+	for _, s := range snapshots.Snapshots(gameID) {
+		if s.Moves > target {
+			break
+		}
+		if events[s.Moves-1].Id != s.EventID {
+			snapshots.DropSnapshots(gameID, s.Moves-1)
+			break
+		}
+		if len(s.Since) > len(s.MoveList) {
+			continue
+		}
+		history := s.MoveList[:len(s.MoveList)-len(s.Since)]
+		restored, err := chess.RestoreGame(s.RepetitionFEN, s.Since, append([]string(nil), history...))
+		if err != nil || restored.FEN() != s.FEN {
+			continue
+		}
+		game, from = restored, s.Moves
+	}
+
+	Aggregate(game, events[from:], gameID, target-from)
+
+	if target-from >= SnapshotInterval {
+		fen, since := game.Repetitions()
+		snapshots.SaveSnapshot(store.Snapshot{
+			AggregateID:   gameID,
+			EventID:       events[target-1].Id,
+			Moves:         target,
+			FEN:           game.FEN(),
+			MoveList:      game.Moves(),
+			RepetitionFEN: fen,
+			Since:         since,
+		})
+	}
+	return game
+}
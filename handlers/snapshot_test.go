@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/store"
+)
+
+var pawnMoves = []string{
+	"8-16", "48-40", "9-17", "49-41", "10-18", "50-42", "11-19", "51-43",
+	"12-20", "52-44", "13-21", "53-45", "14-22", "54-46", "15-23", "55-47",
+	"16-24", "40-32", "17-25", "41-33", "18-26", "42-34",
+}
+
+func moveEvents(gameID string, moves []string) []store.Event {
+	events := make([]store.Event, len(moves))
+	for i, m := range moves {
+		p, _ := ParseMove(m)
+		events[i] = store.Event{Id: i, AggregateID: gameID, EventType: EventMoveSuccess, EventData: store.EncodePayload(p)}
+	}
+	return events
+}
+
+func TestAggregateFromSnapshot(t *testing.T) {
+	const myGameID = "my game"
+	s := store.NewEventStore()
+	events := moveEvents(myGameID, pawnMoves)
+
+	expected := Aggregate(chess.NewGame(), events, myGameID, -1)
+	game := AggregateFromSnapshot(s, events, myGameID, -1)
+	if game.FEN() != expected.FEN() {
+		t.Error("expected position", expected.FEN(), "but got", game.FEN())
+	}
+
+	snapshots := s.Snapshots(myGameID)
+	if len(snapshots) != 1 || snapshots[0].Moves != len(pawnMoves) || snapshots[0].EventID != len(pawnMoves)-1 {
+		t.Fatal("expected a snapshot covering every move but got", snapshots)
+	}
+
+	// replaying from the snapshot must give the same game
+	game = AggregateFromSnapshot(s, events, myGameID, -1)
+	if game.FEN() != expected.FEN() || !reflect.DeepEqual(game.Moves(), expected.Moves()) {
+		t.Error("expected", expected.FEN(), expected.Moves(), "but got", game.FEN(), game.Moves())
+	}
+
+	// asking for fewer moves than the snapshot covers can't use it
+	expected = Aggregate(chess.NewGame(), events, myGameID, 5)
+	if game := AggregateFromSnapshot(s, events, myGameID, 5); game.FEN() != expected.FEN() {
+		t.Error("expected position", expected.FEN(), "but got", game.FEN())
+	}
+}
+
+func TestAggregateFromSnapshotAfterRollback(t *testing.T) {
+	const myGameID = "my game"
+	s := store.NewEventStore()
+	events := moveEvents(myGameID, pawnMoves)
+	AggregateFromSnapshot(s, events, myGameID, -1)
+
+	// roll back the last move and play a different one instead
+	last := len(events)
+	events = append(events,
+		store.Event{Id: last, AggregateID: myGameID, EventType: EventRollbackSuccess},
+		store.Event{Id: last + 1, AggregateID: myGameID, EventType: EventMoveSuccess, EventData: store.EncodePayload(MovePayload{From: 43, To: 35})},
+	)
+	filtered := FilterEvents(events, myGameID)
+
+	expected := Aggregate(chess.NewGame(), filtered, myGameID, -1)
+	game := AggregateFromSnapshot(s, filtered, myGameID, -1)
+	if game.FEN() != expected.FEN() {
+		t.Error("expected the rolled back move to be gone, expected", expected.FEN(), "but got", game.FEN())
+	}
+	for _, snapshot := range s.Snapshots(myGameID) {
+		if snapshot.EventID == last-1 {
+			t.Error("expected the snapshot covering the rolled back move to be dropped but got", snapshot)
+		}
+	}
+}
+
+func TestAggregateFromSnapshotCountsRepetitions(t *testing.T) {
+	const myGameID = "my game"
+	s := store.NewEventStore()
+	knights := []string{"6-21", "62-45", "21-6", "45-62"}
+	var moves []string
+	for i := 0; i < 2; i++ {
+		moves = append(moves, knights...)
+	}
+	// from here on the same position comes up every four moves
+	moves = append(moves, "12-20", "52-44")
+	for i := 0; i < 3; i++ {
+		moves = append(moves, knights...)
+	}
+	events := moveEvents(myGameID, moves)
+	if game := AggregateFromSnapshot(s, events, myGameID, -1); game.Status() != 0 {
+		t.Fatal("expected the game to go on after four repetitions")
+	}
+	if len(s.Snapshots(myGameID)) != 1 {
+		t.Fatal("expected a snapshot but got", s.Snapshots(myGameID))
+	}
+
+	events = moveEvents(myGameID, append(moves, knights...))
+	expected := Aggregate(chess.NewGame(), events, myGameID, -1)
+	game := AggregateFromSnapshot(s, events, myGameID, -1)
+	if expected.Status() != 3 || game.Status() != 3 {
+		t.Error("expected the fifth repetition to draw the game from the snapshot too but got", game.Status())
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcarol/go-chess/chess"
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+	"golang.org/x/net/websocket"
+)
+
+// loadTest is a number of simulated players, each playing both sides of a
+// game at a time through /board and watching it over /ws like a browser
+type loadTest struct {
+	// base is the URL of the server, as in http://localhost:8080
+	base     string
+	players  int
+	duration time.Duration
+	// rollback is the odds that a player takes its last move back instead of moving
+	rollback float64
+	// maxMoves is how many commands a player sends in a game before starting another
+	maxMoves int
+	// timeout is how long a player waits for the outcome of a command
+	timeout time.Duration
+	seed    int64
+	client  *http.Client
+
+	mu     sync.Mutex
+	report loadReport
+}
+
+// loadReport is what a load test measured
+type loadReport struct {
+	Commands      int
+	Failed        int
+	TimedOut      int
+	GamesStarted  int
+	GamesFinished int
+	Promotions    int
+	Rollbacks     int
+	// Latencies are the times from posting a command to its outcome arriving over the websocket
+	Latencies []time.Duration
+}
+
+// loadTestCommand plays random legal moves against a go-chess until the
+// duration is up and reports throughput, latency, failures and memory growth.
+// Without -addr it runs against a server it starts on localhost, keeping the
+// log in a temporary directory rather than in dataDir.
+func loadTestCommand(_ string, args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	addr := flags.String("addr", "", "go-chess to load, as in http://localhost:8080, empty starts one in-process")
+	players := flags.Int("players", 10, "how many players play at once")
+	duration := flags.Duration("duration", 30*time.Second, "how long to play for")
+	rollback := flags.Float64("rollback", 0.05, "odds that a player takes its last move back instead of moving")
+	maxMoves := flags.Int("max-moves", 200, "how many commands a player sends in a game before starting a new one")
+	timeout := flags.Duration("timeout", 10*time.Second, "how long a player waits for the outcome of a command")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the players' moves")
+	workers := flags.Int("workers", runtime.NumCPU(), "how many games the in-process server's handlers work on at once")
+	verbose := flags.Bool("v", false, "keep the in-process server's log")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	l := &loadTest{
+		base:     strings.TrimSuffix(*addr, "/"),
+		players:  *players,
+		duration: *duration,
+		rollback: *rollback,
+		maxMoves: *maxMoves,
+		timeout:  *timeout,
+		seed:     *seed,
+		client:   &http.Client{Timeout: *timeout},
+	}
+	if l.base == "" {
+		if !*verbose {
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+		}
+		stop, err := l.serve(*workers)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	before := heap()
+	start := time.Now()
+	report := l.run()
+	elapsed := time.Since(start)
+	after := heap()
+
+	fmt.Printf("%d players for %v against %s, seed %d\n", l.players, elapsed.Round(time.Millisecond), l.base, l.seed)
+	fmt.Printf("%d commands, %.1f/s, %d promotions, %d rollbacks\n",
+		report.Commands, float64(report.Commands)/elapsed.Seconds(), report.Promotions, report.Rollbacks)
+	fmt.Printf("%d failed, %d timed out\n", report.Failed, report.TimedOut)
+	fmt.Printf("%d games started, %d finished\n", report.GamesStarted, report.GamesFinished)
+	fmt.Printf("latency p50 %v, p90 %v, p99 %v, max %v\n",
+		percentile(report.Latencies, .5), percentile(report.Latencies, .9), percentile(report.Latencies, .99), percentile(report.Latencies, 1))
+	fmt.Printf("heap %.1fMB -> %.1fMB, %d goroutines\n", float64(before)/(1<<20), float64(after)/(1<<20), runtime.NumGoroutine())
+	if *addr != "" {
+		fmt.Println("the heap is the load test's own, the server's is on its /metrics")
+	}
+	if failed := report.Failed + report.TimedOut; failed > 0 {
+		return fmt.Errorf("%d of %d commands failed", failed, report.Commands)
+	}
+	return nil
+}
+
+// serve starts a go-chess on localhost for the players and returns what stops it
+func (l *loadTest) serve(workers int) (func(), error) {
+	dir, err := ioutil.TempDir("", "go-chess-loadtest")
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.NewFileEventStore(dir, handlers.EventTypes())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.Run()
+	a, err := newApi(s, "", workers)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	srv := &http.Server{Handler: a.routes()}
+	go srv.Serve(ln)
+	l.base = "http://" + ln.Addr().String()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx, srv, a, s); err != nil {
+			fmt.Fprintln(os.Stderr, "failed shutting the server down:", err)
+		}
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// run plays until the duration is up and returns what was measured
+func (l *loadTest) run() loadReport {
+	ctx, cancel := context.WithTimeout(context.Background(), l.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < l.players; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(l.seed + int64(i)))
+			for ctx.Err() == nil {
+				l.play(ctx, rng)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sort.Slice(l.report.Latencies, func(i, j int) bool {
+		return l.report.Latencies[i] < l.report.Latencies[j]
+	})
+	return l.report
+}
+
+func (l *loadTest) record(f func(r *loadReport)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f(&l.report)
+}
+
+// play creates a game and plays it until it ends, the player ran out of
+// moves or ctx is done. It gives up on the game when a command fails.
+func (l *loadTest) play(ctx context.Context, rng *rand.Rand) {
+	gameID, err := l.create()
+	if err != nil {
+		l.record(func(r *loadReport) { r.Failed++ })
+		// don't hammer a server that can't create games
+		time.Sleep(100 * time.Millisecond)
+		return
+	}
+	ws, outcomes, err := l.watch(gameID)
+	if err != nil {
+		l.record(func(r *loadReport) { r.Failed++ })
+		return
+	}
+	defer ws.Close()
+	l.record(func(r *loadReport) { r.GamesStarted++ })
+
+	var played []string
+	mirror := chess.NewGame()
+	for moves := 0; moves < l.maxMoves && ctx.Err() == nil; moves++ {
+		valid := mirror.ValidMoves()
+		if mirror.Status() != 0 || len(valid) == 0 {
+			l.record(func(r *loadReport) { r.GamesFinished++ })
+			return
+		}
+		c := command{AggregateId: gameID, Type: "rollback", IdempotencyKey: fmt.Sprintf("%s-%d", gameID, moves)}
+		if len(played) == 0 || rng.Float64() >= l.rollback {
+			c.Data = valid[rng.Intn(len(valid))]
+			c.Type = "move"
+			if strings.Count(c.Data, "-") == 2 {
+				c.Type = "promote"
+			}
+		}
+		latency, err := l.send(c, outcomes)
+		l.record(func(r *loadReport) {
+			r.Commands++
+			switch {
+			case err == errTimedOut:
+				r.TimedOut++
+			case err != nil:
+				r.Failed++
+			default:
+				r.Latencies = append(r.Latencies, latency)
+				if c.Type == "promote" {
+					r.Promotions++
+				} else if c.Type == "rollback" {
+					r.Rollbacks++
+				}
+			}
+		})
+		if err != nil {
+			return
+		}
+
+		if c.Type == "rollback" {
+			played = played[:len(played)-1]
+			mirror = chess.NewGame()
+			for _, m := range played {
+				replay(mirror, m)
+			}
+		} else {
+			played = append(played, c.Data)
+			replay(mirror, c.Data)
+		}
+	}
+}
+
+// replay plays a move or promotion as ValidMoves returned it
+func replay(g *chess.Game, query string) {
+	if strings.Count(query, "-") == 2 {
+		g.Promote(query)
+	} else {
+		g.Move(query)
+	}
+}
+
+// create asks the server for a new game and returns its id
+func (l *loadTest) create() (string, error) {
+	resp, err := l.client.Get(l.base + "/create")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
+	gameID := location.Query().Get("game_id")
+	if gameID == "" {
+		return "", fmt.Errorf("the server created no game, got status %d", resp.StatusCode)
+	}
+	return gameID, nil
+}
+
+// watch opens a websocket on a game and returns the outcomes it receives
+func (l *loadTest) watch(gameID string) (*websocket.Conn, <-chan wsMessage, error) {
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(l.base, "http")+"/ws", "", l.base)
+	if err != nil {
+		return nil, nil, err
+	}
+	hello := struct {
+		AggregateId string
+		LastEventId int
+	}{gameID, -1}
+	if err := websocket.JSON.Send(ws, hello); err != nil {
+		ws.Close()
+		return nil, nil, err
+	}
+	outcomes := make(chan wsMessage, wsQueueSize)
+	go func() {
+		defer close(outcomes)
+		for {
+			var msg wsMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+			outcomes <- msg
+		}
+	}()
+	return ws, outcomes, nil
+}
+
+// errTimedOut is returned by send when a command's outcome didn't arrive in time
+var errTimedOut = fmt.Errorf("timed out waiting for the outcome")
+
+// send posts a command to /board and waits for its outcome, it returns how long that took
+func (l *loadTest) send(c command, outcomes <-chan wsMessage) (time.Duration, error) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := l.client.Post(l.base+"/board", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return 0, fmt.Errorf("posting %v got status %d", c, resp.StatusCode)
+	}
+	timeout := time.NewTimer(l.timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case msg, ok := <-outcomes:
+			if !ok {
+				return 0, fmt.Errorf("the websocket closed waiting for %v", c)
+			}
+			if msg.IdempotencyKey != c.IdempotencyKey {
+				continue
+			}
+			if msg.Result != "1" {
+				return 0, fmt.Errorf("%v failed", c)
+			}
+			return time.Since(start), nil
+		case <-timeout.C:
+			return 0, errTimedOut
+		}
+	}
+}
+
+// heap returns the bytes in use on the heap once garbage is collected
+func heap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// percentile returns the latency q of the way up the sorted latencies
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(q*float64(len(sorted)-1))].Round(time.Microsecond)
+}
@@ -1,31 +1,131 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/ronna-s/go-chess/store"
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/replication"
+	"github.com/scottcarol/go-chess/store"
 	"golang.org/x/net/websocket"
 )
 
 func main() {
-	store := store.NewEventStore()
+	dataDir := flag.String("data", "data", "directory the event log is kept in")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	follow := flag.String("follow", "", "run as a read-only replica of the go-chess at this URL, as in http://primary:8080")
+	archiveAfter := flag.Duration("archive-after", 24*time.Hour, "how long after a game ends it's archived and compacted out of the log, 0 never archives")
+	workers := flag.Int("workers", runtime.NumCPU(), "how many games the handlers work on at once")
+	idempotencyWindow := flag.Duration("idempotency-window", store.DefaultIdempotencyWindow, "how long retried commands are recognized for")
+	adminToken := flag.String("admin-token", os.Getenv("GO_CHESS_ADMIN_TOKEN"), "bearer token the admin endpoints, /metrics and /replication take, a replica sends it to its leader too (default $GO_CHESS_ADMIN_TOKEN)")
+	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(*dataDir, flag.Args()))
+	}
+
+	store, err := store.NewFileEventStore(*dataDir, handlers.EventTypes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store.SetIdempotencyWindow(*idempotencyWindow)
+	if *follow != "" {
+		store.SetReadOnly()
+	}
 	store.Run()
-	api := newApi(store)
-
-	http.Handle("/images/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
-	http.Handle("/js/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
-	http.Handle("/css/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
-	http.HandleFunc("/debug", api.debugHandler)
-	http.HandleFunc("/game", api.gameHandler)
-	http.HandleFunc("/board", api.boardHandler)
-	http.HandleFunc("/slider", api.sliderHandler)
-	http.HandleFunc("/", api.newGameHandler)
-	http.HandleFunc("/create", api.createGameHandler)
-	http.HandleFunc("/promotions", api.promotionsHandler)
-	http.HandleFunc("/scores", api.scoreHandler)
-
-	http.Handle("/ws", websocket.Handler(api.wsHandler))
-
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	api, err := newApi(store, filepath.Join(*dataDir, "projections"), *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.adminToken = *adminToken
+	if *adminToken == "" {
+		log.Println("no -admin-token, the admin endpoints, /metrics and /replication refuse every request")
+	}
+	// background is cancelled to stop the work that isn't driven by requests
+	background, stopBackground := context.WithCancel(context.Background())
+	if *follow != "" {
+		api.follower = replication.NewFollower(store, strings.TrimSuffix(*follow, "/"), *adminToken)
+		go api.follower.Run(background)
+		log.Println("following", *follow)
+	}
+	if *archiveAfter > 0 {
+		a := &archiver{store: store, games: api.games, grace: *archiveAfter}
+		go a.run(background)
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: api.routes()}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	log.Println("shutting down on", <-signals)
+	stopBackground()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := shutdown(ctx, srv, api, store); err != nil {
+		log.Fatal("failed shutting down cleanly: ", err)
+	}
+}
+
+// routes returns the pages, the commands and the admin endpoints the api serves
+func (a *api) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/images/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
+	mux.Handle("/js/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
+	mux.Handle("/css/", http.StripPrefix("/", http.FileServer(http.Dir("./public/static"))))
+	// every request the api answers is timed
+	handle := func(path string, h http.HandlerFunc) {
+		mux.HandleFunc(path, a.metrics.instrument(path, h))
+	}
+	handle("/debug", a.debugHandler)
+	handle("/game", a.gameHandler)
+	handle("/board", a.boardHandler)
+	handle("/slider", a.sliderHandler)
+	handle("/", a.newGameHandler)
+	handle("/create", a.createGameHandler)
+	handle("/promotions", a.promotionsHandler)
+	handle("/scores", a.scoreHandler)
+	handle("/games", a.gamesHandler)
+	// the admin endpoints, the metrics and the replication stream take the admin token
+	handle("/admin/deadletters", a.adminOnly(a.deadLettersHandler))
+	handle("/admin/projections", a.adminOnly(a.projectionsHandler))
+	handle("/admin/verify", a.adminOnly(a.verifyHandler))
+	handle("/admin/scheduled", a.adminOnly(a.scheduledHandler))
+	handle("/admin/replication", a.adminOnly(a.replicationStatusHandler))
+	handle("/admin/asof", a.adminOnly(a.asOfHandler))
+	mux.HandleFunc("/metrics", a.adminOnly(a.metricsHandler))
+	mux.HandleFunc("/replication", a.adminOnly(replication.Handler(a.store).ServeHTTP))
+
+	mux.Handle("/ws", websocket.Handler(a.wsHandler))
+	return mux
+}
+
+// shutdownTimeout bounds how long the server waits for requests and listeners to finish when it's stopped
+const shutdownTimeout = 30 * time.Second
+
+// shutdown stops taking requests, lets the store's listeners handle the events
+// in flight and saves the read models. The websockets are closed last so the
+// browsers get the results of the commands they sent.
+func shutdown(ctx context.Context, srv *http.Server, a *api, s *store.EventStore) error {
+	err := srv.Shutdown(ctx)
+	if serr := s.Shutdown(ctx); err == nil {
+		err = serr
+	}
+	if perr := a.projections.Save(); err == nil {
+		err = perr
+	}
+	a.closeWebsockets()
+	return err
 }
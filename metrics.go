@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/metrics"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// apiMetrics are what /metrics serves, the ones that aren't kept here are read
+// from the store and the api when they're scraped
+type apiMetrics struct {
+	registry  *metrics.Registry
+	requests  *metrics.Histogram
+	aggregate *metrics.Histogram
+	moves     *metrics.Counter
+}
+
+func newApiMetrics(a *api) *apiMetrics {
+	r := metrics.NewRegistry()
+	m := &apiMetrics{
+		registry: r,
+		requests: r.Histogram("chess_http_request_duration_seconds",
+			"How long the api took to answer requests.", metrics.DefaultBuckets, "handler"),
+		aggregate: r.Histogram("chess_aggregate_duration_seconds",
+			"How long rebuilding a game from its moves took.", metrics.DefaultBuckets),
+		moves: r.Counter("chess_moves_total",
+			"Moves and promotions handled, by whether they were legal.", "handler", "result"),
+	}
+
+	types := handlers.EventTypes()
+	r.CounterFunc("chess_events_appended_total", "Events appended since the server started, by type.", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for t, n := range a.store.Appended() {
+			name := strconv.Itoa(t)
+			if et, ok := types.Lookup(t); ok {
+				name = et.Name
+			}
+			samples = append(samples, metrics.Sample{LabelValues: []string{name}, Value: float64(n)})
+		}
+		return samples
+	}, "type")
+	r.GaugeFunc("chess_listeners", "Listeners registered with the store.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(a.store.Listeners())}}
+	})
+	r.GaugeFunc("chess_listener_queue_depth", "Events waiting for a listener.", func() []metrics.Sample {
+		// every websocket has a listener of its own, they're summed up so
+		// connections coming and going don't leave a trail of series
+		queued := map[string]int{}
+		for _, l := range a.store.ListenerStats() {
+			name := l.Name
+			if strings.HasPrefix(name, "websocket ") {
+				name = "websocket"
+			}
+			queued[name] += l.Queued
+		}
+		samples := make([]metrics.Sample, 0, len(queued))
+		for name, n := range queued {
+			samples = append(samples, metrics.Sample{LabelValues: []string{name}, Value: float64(n)})
+		}
+		return samples
+	}, "listener")
+	r.GaugeFunc("chess_websocket_connections", "Open websocket connections.", func() []metrics.Sample {
+		a.websocketsMu.Lock()
+		defer a.websocketsMu.Unlock()
+		return []metrics.Sample{{Value: float64(len(a.websockets))}}
+	})
+	r.GaugeFunc("chess_replication_lag_events", "Events a replica is behind its leader, there's none on a leader.", func() []metrics.Sample {
+		if a.follower == nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(a.follower.Status().Lag)}}
+	})
+	return m
+}
+
+// instrument times the requests h answers
+func (m *apiMetrics) instrument(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h(w, r)
+		m.requests.Observe(time.Since(start).Seconds(), name)
+	}
+}
+
+// timeAggregate records how long a game took to rebuild since start,
+// apis put together by tests may have no metrics
+func (m *apiMetrics) timeAggregate(start time.Time) {
+	if m != nil {
+		m.aggregate.Observe(time.Since(start).Seconds())
+	}
+}
+
+// countMoves wraps the persister a handler is given to count the moves it
+// persists, without metrics it's handed back as it is
+func (m *apiMetrics) countMoves(handler string, p handlers.EventPersister) handlers.EventPersister {
+	if m == nil {
+		return p
+	}
+	return movesPersister{EventPersister: p, handler: handler, moves: m.moves}
+}
+
+type movesPersister struct {
+	handlers.EventPersister
+	handler string
+	moves   *metrics.Counter
+}
+
+func (p movesPersister) Persist(e store.Event) error {
+	err := p.EventPersister.Persist(e)
+	if err != nil {
+		return err
+	}
+	switch e.EventType {
+	case handlers.EventMoveSuccess, handlers.EventPromotionSuccess:
+		p.moves.Inc(p.handler, "success")
+	case handlers.EventMoveFail, handlers.EventPromotionFail:
+		p.moves.Inc(p.handler, "fail")
+	}
+	return nil
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func (a *api) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.registry.Handler().ServeHTTP(w, r)
+}
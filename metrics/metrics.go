@@ -0,0 +1,334 @@
+// Package metrics keeps counters and histograms and writes them, together
+// with values read when they're scraped, in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds,
+// from a millisecond to ten seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is a value of a metric read when it's scraped,
+// LabelValues go with the label names the metric was registered with
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// metric is anything a Registry can write out
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// desc is what every metric has
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// Registry holds metrics in the order they're registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) add(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, typ: "counter", labels: labels}, values: map[string]*series{}}
+	r.add(name, c)
+	return c
+}
+
+// Histogram registers a histogram with the given bucket upper bounds and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: append([]float64(nil), buckets...),
+		values:  map[string]*histogramSeries{},
+	}
+	sort.Float64s(h.buckets)
+	r.add(name, h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose samples are read from collect when it's scraped
+func (r *Registry) GaugeFunc(name, help string, collect func() []Sample, labels ...string) {
+	r.add(name, &collected{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, collect: collect})
+}
+
+// CounterFunc registers a counter whose samples are read from collect when it's scraped
+func (r *Registry) CounterFunc(name, help string, collect func() []Sample, labels ...string) {
+	r.add(name, &collected{desc: desc{name: name, help: help, typ: "counter", labels: labels}, collect: collect})
+}
+
+// WriteTo writes every metric in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics to a Prometheus scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// series is the value of a metric for one set of label values
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// Counter is a value that only goes up, one for every set of label values
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*series
+}
+
+// Inc adds 1 to the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which mustn't be negative, to the counter of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s can't go down", c.name))
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the counter of the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.values[c.key(labelValues)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	samples := make([]Sample, 0, len(c.values))
+	for _, s := range c.values {
+		samples = append(samples, Sample{LabelValues: s.labelValues, Value: s.value})
+	}
+	c.mu.Unlock()
+	writeSamples(w, c.desc, samples)
+}
+
+// key checks the label values against the label names and joins them
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v but got values %v", d.name, d.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// Histogram counts observations in buckets, one set for every set of label values
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	// counts holds the observations in every bucket, not the ones below it
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns how many observations the histogram of the label values has
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.values[h.key(labelValues)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	all := make([]*histogramSeries, 0, len(h.values))
+	for _, s := range h.values {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return lessLabels(all[i].labelValues, all[j].labelValues)
+	})
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range all {
+		bucketValues := append(append([]string(nil), s.labelValues...), "")
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			bucketValues[len(bucketValues)-1] = formatFloat(upper)
+			writeSample(w, h.name+"_bucket", bucketLabels, bucketValues, float64(cumulative))
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		writeSample(w, h.name+"_bucket", bucketLabels, bucketValues, float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labelValues, s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// collected is a metric read when it's scraped
+type collected struct {
+	desc
+	collect func() []Sample
+}
+
+func (c *collected) write(w *bufio.Writer) {
+	samples := c.collect()
+	for _, s := range samples {
+		c.key(s.LabelValues)
+	}
+	writeSamples(w, c.desc, samples)
+}
+
+func writeSamples(w *bufio.Writer, d desc, samples []Sample) {
+	d.header(w)
+	sort.Slice(samples, func(i, j int) bool {
+		return lessLabels(samples[i].LabelValues, samples[j].LabelValues)
+	})
+	for _, s := range samples {
+		writeSample(w, d.name, d.labels, s.LabelValues, s.Value)
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
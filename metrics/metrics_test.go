@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests answered.\nBy path.", "path", "code")
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`/"quoted"\`, "500")
+	latency := r.Histogram("latency_seconds", "Request latency.", []float64{0.5, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.3)
+	latency.Observe(2)
+	r.GaugeFunc("queued", "Events waiting.", func() []Sample {
+		return []Sample{{Value: 3}}
+	})
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP requests_total Requests answered.\nBy path.
+# TYPE requests_total counter
+requests_total{path="/\"quoted\"\\",code="500"} 1
+requests_total{path="/a",code="200"} 2
+requests_total{path="/b",code="200"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="0.5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.35
+latency_seconds_count 3
+# HELP queued Events waiting.
+# TYPE queued gauge
+queued 3
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits_total", "Hits.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Error("expected the text exposition format but got", ct)
+	}
+	if !strings.Contains(w.Body.String(), "\nhits_total 1\n") {
+		t.Error("expected the counter but got", w.Body.String())
+	}
+}
+
+func TestLabelsMustMatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a counter given the wrong number of label values to panic")
+		}
+	}()
+	NewRegistry().Counter("requests_total", "Requests.", "path").Inc()
+}
@@ -0,0 +1,160 @@
+package projection
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// GameSummary is what's known about a game without replaying it
+type GameSummary struct {
+	ID    string
+	Moves int
+	// LastEventID is the Id of the game's latest event
+	LastEventID int
+	LastPlayed  time.Time
+	// Result is how the game ended, "" while it's on
+	Result string
+	// Archived is set once the game's events may be compacted away
+	Archived bool `json:",omitempty"`
+}
+
+// Games keeps a summary of every game
+type Games struct {
+	mu    sync.RWMutex
+	games map[string]*GameSummary
+}
+
+func NewGames() *Games {
+	return &Games{games: map[string]*GameSummary{}}
+}
+
+func (g *Games) Name() string {
+	return "games"
+}
+
+func (g *Games) Apply(e store.Event) {
+	if a, archived, ok := archivedGame(e); ok {
+		g.archive(e, a, archived)
+		return
+	}
+	if store.IsSystemAggregate(e.AggregateID) {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	game, ok := g.games[e.AggregateID]
+	if !ok {
+		game = &GameSummary{ID: e.AggregateID}
+		g.games[e.AggregateID] = game
+	}
+	game.LastEventID = e.Id
+	game.LastPlayed = e.Metadata.Timestamp
+	switch e.EventType {
+	case handlers.EventMoveSuccess, handlers.EventPromotionSuccess:
+		game.Moves++
+		// a game that ended without a mate can still be played on,
+		// it's on again until a new result comes
+		game.Result = ""
+	case handlers.EventRollbackSuccess:
+		if game.Moves > 0 {
+			game.Moves--
+		}
+		// taking back the last move takes back the result it led to
+		game.Result = ""
+	default:
+		if r := result(e.EventType); r != "" {
+			game.Result = r
+		}
+	}
+}
+
+// archive marks a game archived, it's summed up from its archive when the
+// game's own events were compacted away before the projection applied them
+func (g *Games) archive(e store.Event, a store.Archive, archived handlers.ArchivedGame) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	game, ok := g.games[a.AggregateID]
+	if !ok {
+		game = &GameSummary{ID: a.AggregateID, LastEventID: e.Id}
+		g.games[a.AggregateID] = game
+	}
+	game.Moves = len(archived.Moves)
+	game.Result = result(archived.Result)
+	// a game whose archive was cut down to before it ended wasn't archived yet,
+	// nor is it known when it was last played
+	if game.Result != "" {
+		game.LastPlayed = archived.Ended
+		game.Archived = true
+	}
+}
+
+func (g *Games) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.games = map[string]*GameSummary{}
+}
+
+// Game returns the summary of a game, false if it has no events
+func (g *Games) Game(id string) (GameSummary, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	game, ok := g.games[id]
+	if !ok {
+		return GameSummary{}, false
+	}
+	return *game, true
+}
+
+// Active returns the games that haven't ended, the most recently played first
+func (g *Games) Active() []GameSummary {
+	g.mu.RLock()
+	active := []GameSummary{}
+	for _, game := range g.games {
+		if game.Result == "" {
+			active = append(active, *game)
+		}
+	}
+	g.mu.RUnlock()
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastEventID > active[j].LastEventID
+	})
+	return active
+}
+
+// Finished returns the games that ended and weren't archived, the longest ended first
+func (g *Games) Finished() []GameSummary {
+	g.mu.RLock()
+	finished := []GameSummary{}
+	for _, game := range g.games {
+		if game.Result != "" && !game.Archived {
+			finished = append(finished, *game)
+		}
+	}
+	g.mu.RUnlock()
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].LastPlayed.Before(finished[j].LastPlayed)
+	})
+	return finished
+}
+
+func (g *Games) MarshalJSON() ([]byte, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return json.Marshal(g.games)
+}
+
+func (g *Games) UnmarshalJSON(data []byte) error {
+	games := map[string]*GameSummary{}
+	if err := json.Unmarshal(data, &games); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.games = games
+	return nil
+}
@@ -0,0 +1,93 @@
+package projection
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// MoveLists keeps the moves and promotions of every game that weren't rolled
+// back, the same events handlers.FilterEvents picks out of a game's stream.
+// Only what's needed to replay a move is kept of its event, and a game is
+// dropped once it's archived, its archive holds its moves from then on.
+type MoveLists struct {
+	mu    sync.RWMutex
+	moves map[string][]move
+}
+
+// move is what's kept of the event of a move
+type move struct {
+	Id        int
+	EventType int
+	EventData string
+}
+
+func NewMoveLists() *MoveLists {
+	return &MoveLists{moves: map[string][]move{}}
+}
+
+func (m *MoveLists) Name() string {
+	return "moves"
+}
+
+func (m *MoveLists) Apply(e store.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, game, ok := archivedGame(e); ok {
+		delete(m.moves, a.AggregateID)
+		// an archive cut down by store.AsOf is of a game that hadn't ended yet
+		if game.Result == 0 {
+			for _, e := range game.MoveEvents(a.AggregateID) {
+				m.moves[a.AggregateID] = append(m.moves[a.AggregateID], move{Id: e.Id, EventType: e.EventType, EventData: e.EventData})
+			}
+		}
+		return
+	}
+	moves := m.moves[e.AggregateID]
+	switch e.EventType {
+	case handlers.EventMoveSuccess, handlers.EventPromotionSuccess:
+		m.moves[e.AggregateID] = append(moves, move{Id: e.Id, EventType: e.EventType, EventData: e.EventData})
+	case handlers.EventRollbackSuccess:
+		if len(moves) > 0 {
+			m.moves[e.AggregateID] = moves[:len(moves)-1]
+		}
+	}
+}
+
+func (m *MoveLists) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.moves = map[string][]move{}
+}
+
+// Moves returns the moves of a game in the order they were played, none once
+// the game is archived. The events only hold their Id, type and data.
+func (m *MoveLists) Moves(gameID string) []store.Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	moves := m.moves[gameID]
+	events := make([]store.Event, len(moves))
+	for i, mv := range moves {
+		events[i] = store.Event{Id: mv.Id, AggregateID: gameID, EventType: mv.EventType, EventData: mv.EventData}
+	}
+	return events
+}
+
+func (m *MoveLists) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return json.Marshal(m.moves)
+}
+
+func (m *MoveLists) UnmarshalJSON(data []byte) error {
+	moves := map[string][]move{}
+	if err := json.Unmarshal(data, &moves); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.moves = moves
+	return nil
+}
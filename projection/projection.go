@@ -0,0 +1,277 @@
+// Package projection keeps read models up to date from the events of a store.
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+// checkpointInterval is how many events a projection applies between saving its checkpoint
+const checkpointInterval = 100
+
+// Projection is a read model built from events. Its state is saved as JSON
+// together with the Id of the last event it applied, so it only has to catch
+// up on the events after that when the server restarts.
+type Projection interface {
+	Name() string
+	// Apply updates the read model with the next event, events come in append order
+	Apply(e store.Event)
+	// Reset drops everything the read model was built from
+	Reset()
+	json.Marshaler
+	json.Unmarshaler
+}
+
+// Checkpoint is how far a projection got
+type Checkpoint struct {
+	Name string
+	// EventID is the Id of the last event the projection applied, -1 if none
+	EventID int
+}
+
+// Manager runs projections off a store, with dir set it saves their state and
+// checkpoint there
+type Manager struct {
+	store *store.EventStore
+	dir   string
+
+	mu          sync.RWMutex
+	projections map[string]*projected
+}
+
+// projected is a projection with the listener feeding it
+type projected struct {
+	projection Projection
+
+	mu         sync.Mutex
+	checkpoint int
+	unsaved    int
+	// generation is bumped on rebuild so a listener that was replaced stops applying
+	generation int
+	listener   *store.EventListener
+	// advanced is closed and replaced every time the checkpoint moves
+	advanced chan struct{}
+}
+
+func NewManager(s *store.EventStore, dir string) *Manager {
+	return &Manager{store: s, dir: dir, projections: map[string]*projected{}}
+}
+
+// Register starts running a projection, from its saved checkpoint if it has one
+func (m *Manager) Register(p Projection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.projections[p.Name()]; ok {
+		return fmt.Errorf("projection %s registered twice", p.Name())
+	}
+	pr := &projected{projection: p, checkpoint: -1, advanced: make(chan struct{})}
+	if err := m.load(pr); err != nil {
+		log.Printf("projection %s: rebuilding, can't load its checkpoint: %v", p.Name(), err)
+		p.Reset()
+		pr.checkpoint = -1
+	}
+	m.projections[p.Name()] = pr
+	return m.subscribe(pr)
+}
+
+func (m *Manager) subscribe(pr *projected) error {
+	pr.mu.Lock()
+	generation := pr.generation
+	from := pr.checkpoint
+	pr.listener = &store.EventListener{
+		Name: "projection " + pr.projection.Name(),
+		NotifFn: func(_ *store.EventStore, e store.Event) {
+			m.apply(pr, generation, e)
+		},
+	}
+	l := pr.listener
+	pr.mu.Unlock()
+	return m.store.Subscribe(l, from)
+}
+
+func (m *Manager) apply(pr *projected, generation int, e store.Event) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if generation != pr.generation || e.Id <= pr.checkpoint {
+		return
+	}
+	pr.projection.Apply(e)
+	pr.checkpoint = e.Id
+	close(pr.advanced)
+	pr.advanced = make(chan struct{})
+	if pr.unsaved++; pr.unsaved >= checkpointInterval {
+		if err := m.save(pr); err != nil {
+			log.Printf("projection %s: failed saving checkpoint: %v", pr.projection.Name(), err)
+		}
+	}
+}
+
+// Rebuild drops the state of a projection and replays every event into it
+func (m *Manager) Rebuild(name string) error {
+	pr, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	pr.mu.Lock()
+	l := pr.listener
+	pr.mu.Unlock()
+	m.store.Unregister(l)
+
+	pr.mu.Lock()
+	pr.generation++
+	pr.projection.Reset()
+	pr.checkpoint = -1
+	err = m.save(pr)
+	pr.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.subscribe(pr)
+}
+
+// Replay resets projections that aren't registered with a Manager and applies
+// events to them, with the events of store.AsOf they hold the read models as
+// they were back then
+func Replay(events []store.Event, projections ...Projection) {
+	for _, p := range projections {
+		p.Reset()
+	}
+	for _, e := range events {
+		for _, p := range projections {
+			p.Apply(e)
+		}
+	}
+}
+
+// Wait blocks until a projection has applied the event with the given Id,
+// or ctx is done
+func (m *Manager) Wait(ctx context.Context, name string, id int) error {
+	pr, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	for {
+		pr.mu.Lock()
+		checkpoint, advanced := pr.checkpoint, pr.advanced
+		pr.mu.Unlock()
+		if checkpoint >= id {
+			return nil
+		}
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Checkpoints returns how far every projection got, by name
+func (m *Manager) Checkpoints() []Checkpoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	checkpoints := make([]Checkpoint, 0, len(m.projections))
+	for name, pr := range m.projections {
+		pr.mu.Lock()
+		checkpoints = append(checkpoints, Checkpoint{Name: name, EventID: pr.checkpoint})
+		pr.mu.Unlock()
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Name < checkpoints[j].Name
+	})
+	return checkpoints
+}
+
+// Save writes the state and checkpoint of every projection
+func (m *Manager) Save() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pr := range m.projections {
+		pr.mu.Lock()
+		err := m.save(pr)
+		pr.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) get(name string) (*projected, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pr, ok := m.projections[name]
+	if !ok {
+		return nil, fmt.Errorf("no projection named %s", name)
+	}
+	return pr, nil
+}
+
+// saved is the file a projection is saved in
+type saved struct {
+	Checkpoint int
+	State      json.RawMessage
+}
+
+func (m *Manager) path(pr *projected) string {
+	return filepath.Join(m.dir, pr.projection.Name()+".json")
+}
+
+// save must be called holding pr.mu
+func (m *Manager) save(pr *projected) error {
+	pr.unsaved = 0
+	if m.dir == "" {
+		return nil
+	}
+	state, err := pr.projection.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(saved{Checkpoint: pr.checkpoint, State: state})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	// write aside and rename so a crash never leaves half a file behind
+	tmp := m.path(pr) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path(pr))
+}
+
+func (m *Manager) load(pr *projected) error {
+	if m.dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(m.path(pr))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s saved
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	// the log lost its tail since, the state holds events that are gone
+	if s.Checkpoint > m.store.LastID() {
+		return fmt.Errorf("checkpoint %d is past the last event %d", s.Checkpoint, m.store.LastID())
+	}
+	if err := pr.projection.UnmarshalJSON(s.State); err != nil {
+		return err
+	}
+	pr.checkpoint = s.Checkpoint
+	return nil
+}
@@ -0,0 +1,228 @@
+package projection
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+// counting is a scoreboard that counts the events it's handed
+type counting struct {
+	*Scoreboard
+	applied int
+}
+
+func (c *counting) Apply(e store.Event) {
+	c.applied++
+	c.Scoreboard.Apply(e)
+}
+
+func persist(t *testing.T, s *store.EventStore, events ...store.Event) int {
+	var last store.Event
+	for _, e := range events {
+		var err error
+		if last, err = s.Persist(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return last.Id
+}
+
+func wait(t *testing.T, m *Manager, name string, id int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Wait(ctx, name, id); err != nil {
+		t.Fatal("projection", name, "didn't get to event", id, err)
+	}
+}
+
+func TestManagerResumesFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-chess-projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.NewEventStore()
+	s.Run()
+	m := NewManager(s, dir)
+	scores := &counting{Scoreboard: NewScoreboard()}
+	if err := m.Register(scores); err != nil {
+		t.Fatal(err)
+	}
+	last := persist(t, s,
+		store.Event{AggregateID: "first", EventType: handlers.EventMoveSuccess},
+		store.Event{AggregateID: "first", EventType: handlers.EventWhiteWins},
+	)
+	wait(t, m, scores.Name(), last)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	last = persist(t, s, store.Event{AggregateID: "second", EventType: handlers.EventDraw})
+
+	restarted := NewManager(s, dir)
+	resumed := &counting{Scoreboard: NewScoreboard()}
+	if err := restarted.Register(resumed); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, restarted, resumed.Name(), last)
+
+	expected := []Score{{GameName: "first", Type: "Blue wins"}, {GameName: "second", Type: "Draw"}}
+	if !reflect.DeepEqual(resumed.Scores(), expected) {
+		t.Error("expected", expected, "but got", resumed.Scores())
+	}
+	if resumed.applied != 1 {
+		t.Error("expected to only apply the event after the checkpoint but applied", resumed.applied)
+	}
+}
+
+func TestManagerRebuild(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	m := NewManager(s, "")
+	games := NewGames()
+	if err := m.Register(games); err != nil {
+		t.Fatal(err)
+	}
+	last := persist(t, s,
+		store.Event{AggregateID: "first", EventType: handlers.EventMoveSuccess},
+		store.Event{AggregateID: "second", EventType: handlers.EventMoveSuccess},
+		store.Event{AggregateID: "second", EventType: handlers.EventBlackWins},
+	)
+	wait(t, m, games.Name(), last)
+	before := games.Active()
+
+	if err := m.Rebuild(games.Name()); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, m, games.Name(), last)
+	if after := games.Active(); !reflect.DeepEqual(after, before) {
+		t.Error("expected the rebuilt games", after, "to be", before)
+	}
+	if len(before) != 1 || before[0].ID != "first" || before[0].Moves != 1 {
+		t.Error("expected only the first game to be active but got", before)
+	}
+	if err := m.Rebuild("nothing"); err == nil {
+		t.Error("expected rebuilding an unknown projection to fail")
+	}
+}
+
+func TestMoveListsMatchFilterEvents(t *testing.T) {
+	const myGameID = "my game"
+	var events []store.Event
+	for i, typ := range []int{
+		handlers.EventMoveSuccess, handlers.EventMoveSuccess, handlers.EventRollbackSuccess,
+		handlers.EventMoveFail, handlers.EventPromotionSuccess, handlers.EventRollbackSuccess,
+		handlers.EventRollbackSuccess, handlers.EventRollbackSuccess, handlers.EventMoveSuccess,
+	} {
+		events = append(events, store.Event{Id: i, AggregateID: myGameID, EventType: typ})
+	}
+
+	moves := NewMoveLists()
+	var held []store.Event
+	for _, e := range events {
+		moves.Apply(e)
+		if e.Id == 1 {
+			held = moves.Moves(myGameID)
+		}
+	}
+	if expected := handlers.FilterEvents(events, myGameID); !reflect.DeepEqual(moves.Moves(myGameID), expected) {
+		t.Error("expected", expected, "but got", moves.Moves(myGameID))
+	}
+	if held[1].Id != 1 {
+		t.Error("expected the moves handed out before a rollback to stay put but got", held)
+	}
+}
+
+func TestRebuildAfterCompaction(t *testing.T) {
+	s := store.NewEventStore()
+	s.Run()
+	move := func(query string) store.Event {
+		p, _ := handlers.ParseMove(query)
+		return store.Event{AggregateID: "over", EventType: handlers.EventMoveSuccess, EventData: store.EncodePayload(p)}
+	}
+	persist(t, s, move("13-21"), move("52-36"), store.Event{AggregateID: "over", EventType: handlers.EventMoveFail},
+		move("14-30"), move("59-31"), store.Event{AggregateID: "over", EventType: handlers.EventBlackWins})
+	summary, ok := handlers.ArchiveGame(s.ReadStream("over", 0))
+	if !ok {
+		t.Fatal("expected the game to be over")
+	}
+	archived, err := s.Archive("over", store.AnyVersion, summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(s, "")
+	scores, games, moves := NewScoreboard(), NewGames(), NewMoveLists()
+	for _, p := range []Projection{scores, games, moves} {
+		if err := m.Register(p); err != nil {
+			t.Fatal(err)
+		}
+		wait(t, m, p.Name(), archived.Id)
+	}
+	if held := moves.Moves("over"); len(held) != 0 {
+		t.Error("expected the archived game's moves to be dropped but got", held)
+	}
+
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Projection{scores, games, moves} {
+		if err := m.Rebuild(p.Name()); err != nil {
+			t.Fatal(err)
+		}
+		wait(t, m, p.Name(), archived.Id)
+	}
+
+	if expected := []Score{{GameName: "over", Type: "Pink wins"}}; !reflect.DeepEqual(scores.Scores(), expected) {
+		t.Error("expected", expected, "but got", scores.Scores())
+	}
+	if game, _ := games.Game("over"); !game.Archived || game.Moves != 4 || game.Result != "Pink wins" {
+		t.Error("expected the archived game to be summed up from its archive but got", game)
+	}
+	if held := moves.Moves("over"); len(held) != 0 {
+		t.Error("expected the rebuilt move lists to skip the archived game but got", held)
+	}
+}
+
+func TestScoreboardMatchesGamesAfterARollback(t *testing.T) {
+	scoreboard, games := NewScoreboard(), NewGames()
+	for i, e := range []store.Event{
+		{AggregateID: "mated", EventType: handlers.EventMoveSuccess},
+		{AggregateID: "mated", EventType: handlers.EventBlackWins},
+		{AggregateID: "drawn", EventType: handlers.EventDraw},
+		{AggregateID: "other", EventType: handlers.EventWhiteWins},
+		// the mate is taken back, the draw is played on
+		{AggregateID: "mated", EventType: handlers.EventRollbackSuccess},
+		{AggregateID: "drawn", EventType: handlers.EventPromotionSuccess},
+		{AggregateID: "drawn", EventType: handlers.EventWhiteWins},
+	} {
+		e.Id = i
+		scoreboard.Apply(e)
+		games.Apply(e)
+	}
+	expected := []Score{{GameName: "other", Type: "Blue wins"}, {GameName: "drawn", Type: "Blue wins"}}
+	if scores := scoreboard.Scores(); !reflect.DeepEqual(scores, expected) {
+		t.Error("expected the scores", expected, "but got", scores)
+	}
+	for _, score := range scoreboard.Scores() {
+		if game, _ := games.Game(score.GameName); game.Result != score.Type {
+			t.Error("expected the scoreboard to agree with the games on", score.GameName, "but got", score.Type, "and", game.Result)
+		}
+	}
+	if game, _ := games.Game("mated"); game.Result != "" {
+		t.Error("expected the taken back mate to leave no result but got", game.Result)
+	}
+
+	// an entry that moved up is still found by its game
+	scoreboard.Apply(store.Event{Id: 7, AggregateID: "drawn", EventType: handlers.EventDraw})
+	if scores := scoreboard.Scores(); len(scores) != 2 || scores[1].Type != "Draw" {
+		t.Error("expected the second game's result to be updated in place but got", scores)
+	}
+}
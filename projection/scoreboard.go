@@ -0,0 +1,131 @@
+package projection
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/scottcarol/go-chess/handlers"
+	"github.com/scottcarol/go-chess/store"
+)
+
+type Score struct {
+	GameName string
+	Type     string
+}
+
+// result returns how the event ends a game, "" if it doesn't
+func result(eventType int) string {
+	switch eventType {
+	case handlers.EventWhiteWins:
+		return "Blue wins"
+	case handlers.EventBlackWins:
+		return "Pink wins"
+	case handlers.EventDraw:
+		return "Draw"
+	}
+	return ""
+}
+
+// archivedGame returns the archive and summary of a game if e archives one
+func archivedGame(e store.Event) (store.Archive, handlers.ArchivedGame, bool) {
+	a, ok := store.ArchiveOf(e)
+	if !ok {
+		return a, handlers.ArchivedGame{}, false
+	}
+	game, err := handlers.DecodeArchivedGame(a)
+	if err != nil {
+		log.Println("projection: skipping unreadable archive", e, err)
+		return a, game, false
+	}
+	return a, game, true
+}
+
+// Scoreboard holds the result of every game that ended, in the order they ended
+type Scoreboard struct {
+	mu     sync.RWMutex
+	scores []Score
+	// index is the position of every game in scores
+	index map[string]int
+}
+
+func NewScoreboard() *Scoreboard {
+	return &Scoreboard{index: map[string]int{}}
+}
+
+func (s *Scoreboard) Name() string {
+	return "scoreboard"
+}
+
+func (s *Scoreboard) Apply(e store.Event) {
+	gameID, r := e.AggregateID, result(e.EventType)
+	// a log that was compacted only has the result in the game's archive
+	if a, game, ok := archivedGame(e); ok {
+		gameID, r = a.AggregateID, result(game.Result)
+	}
+	switch e.EventType {
+	case handlers.EventMoveSuccess, handlers.EventPromotionSuccess, handlers.EventRollbackSuccess:
+		// like in Games, a game played on or taken back is on again
+		// until a new result comes
+		s.clear(gameID)
+		return
+	}
+	if r == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.index[gameID]; ok {
+		s.scores[i].Type = r
+		return
+	}
+	s.index[gameID] = len(s.scores)
+	s.scores = append(s.scores, Score{GameName: gameID, Type: r})
+}
+
+// clear drops the result of a game, the games after it move up
+func (s *Scoreboard) clear(gameID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index[gameID]
+	if !ok {
+		return
+	}
+	s.scores = append(s.scores[:i], s.scores[i+1:]...)
+	delete(s.index, gameID)
+	for j := i; j < len(s.scores); j++ {
+		s.index[s.scores[j].GameName] = j
+	}
+}
+
+func (s *Scoreboard) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scores, s.index = nil, map[string]int{}
+}
+
+func (s *Scoreboard) Scores() []Score {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	scores := make([]Score, len(s.scores))
+	copy(scores, s.scores)
+	return scores
+}
+
+func (s *Scoreboard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Scores())
+}
+
+func (s *Scoreboard) UnmarshalJSON(data []byte) error {
+	var scores []Score
+	if err := json.Unmarshal(data, &scores); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scores, s.index = scores, map[string]int{}
+	for i, score := range scores {
+		s.index[score.GameName] = i
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 var timer;
 
-var ws = new WebSocket("ws://127.0.0.1:8080/ws");
+var ws = new WebSocket("ws://" + location.host + "/ws");
 ws.onclose = function (ev) {
-    var newWs = new WebSocket("ws://127.0.0.1:8080/ws");
+    var newWs = new WebSocket("ws://" + location.host + "/ws");
     newWs.onmessage = ws.onmessage;
     newWs.onclose = ws.onclose;
     newWs.onopen = ws.onopen;
@@ -12,7 +12,8 @@ ws.onclose = function (ev) {
 ws.onopen = function (ev) {
     ws.send(JSON.stringify({
         Type: "hello",
-        AggregateId: gameId
+        AggregateId: gameId,
+        LastEventId: lastEventId
     }));
     if (document.getElementById("movesRange") == null) {
         renderBoard(-1);
@@ -25,7 +26,12 @@ ws.onopen = function (ev) {
 
 ws.onmessage = function(event) {
     var board = document.getElementById("board-div");
-    switch(event.data) {
+    var msg = JSON.parse(event.data);
+    // rejected commands never became events
+    if (msg.Id >= 0) {
+        lastEventId = msg.Id;
+    }
+    switch(msg.Result) {
         case "0":
             shake(board);
             break;
@@ -102,6 +108,41 @@ var shake = function (element, magnitude = 16) {
 
 };
 
+var maxSubmitAttempts = 3;
+
+function newIdempotencyKey() {
+    var bytes = new Uint8Array(16);
+    window.crypto.getRandomValues(bytes);
+    return Array.from(bytes, function (b) {
+        return ("0" + b.toString(16)).slice(-2);
+    }).join("");
+}
+
+// submit posts a command and retries it when the network fails,
+// the retries reuse its key so the server only applies it once
+function submit(msg, attempt) {
+    attempt = attempt || 1;
+    if (!msg.IdempotencyKey) {
+        msg.IdempotencyKey = newIdempotencyKey();
+    }
+    var xhr = new XMLHttpRequest();
+    xhr.open('POST', '/board?game_id=' + gameId);
+    xhr.onload = function () {
+        if (xhr.status !== 201) {
+            alert("bad status code")
+            shake(document.getElementById("board-div"));
+        }
+    };
+    xhr.onerror = function () {
+        if (attempt < maxSubmitAttempts) {
+            submit(msg, attempt + 1);
+        } else {
+            shake(document.getElementById("board-div"));
+        }
+    };
+    xhr.send(JSON.stringify(msg));
+}
+
 function allowDrop(ev) {
     ev.preventDefault();
 }
@@ -116,15 +157,7 @@ function rollback() {
         Type: "rollback",
         AggregateId: gameId
     };
-    xhr = new XMLHttpRequest();
-    xhr.open('POST', '/board?game_id=' + gameId);
-    xhr.onload = function () {
-        if (xhr.status !== 201) {
-            alert("bad status code")
-            shake(document.getElementById("board-div"));
-        }
-    };
-    xhr.send(JSON.stringify(msg));
+    submit(msg);
 
 }
 
@@ -171,15 +204,7 @@ function promote(origPos, newPos, newPiece) {
         AggregateId: gameId
     };
 
-    xhr = new XMLHttpRequest();
-    xhr.open('POST', '/board?game_id=' + gameId);
-    xhr.onload = function () {
-        if (xhr.status !== 201) {
-            alert("bad status code")
-            shake(document.getElementById("board-div"));
-        }
-    };
-    xhr.send(JSON.stringify(msg));
+    submit(msg);
 }
 
 function move(ev) {
@@ -199,14 +224,6 @@ function move(ev) {
             AggregateId: gameId
         };
 
-        xhr = new XMLHttpRequest();
-        xhr.open('POST', '/board?game_id=' + gameId);
-        xhr.onload = function () {
-            if (xhr.status !== 201) {
-                alert("bad status code")
-                shake(document.getElementById("board-div"));
-            }
-        };
-        xhr.send(JSON.stringify(msg));
+        submit(msg);
     }
 }
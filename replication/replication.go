@@ -0,0 +1,259 @@
+// Package replication copies the event log of a leader to read-only followers over HTTP.
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+const (
+	// heartbeatInterval is how often the leader tells an idle follower where its log is at
+	heartbeatInterval = time.Second
+	// queueSize is how many events a follower may fall behind on one connection,
+	// one that falls further behind is cut off and catches up when it reconnects
+	queueSize      = 4096
+	retryInterval  = time.Second
+	requestTimeout = 10 * time.Second
+)
+
+// message is a line of the replication stream, Event is nil on heartbeats.
+// LastID is the Id of the leader's last event when the message was sent.
+type message struct {
+	Event  *store.Event `json:",omitempty"`
+	LastID int
+}
+
+// Handler streams the events of s after the Id in ?from= (-1 for all of them)
+// as JSON lines and keeps streaming new events as they're appended
+func Handler(s *store.EventStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := -1
+		if f := r.URL.Query().Get("from"); f != "" {
+			var err error
+			if from, err = strconv.Atoi(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+			return
+		}
+
+		events := make(chan store.Event)
+		gone := make(chan struct{})
+		var goneOnce sync.Once
+		l := &store.EventListener{
+			Name:      "follower " + r.RemoteAddr,
+			QueueSize: queueSize,
+			Overflow:  store.OverflowDisconnect,
+			OnDisconnect: func() {
+				goneOnce.Do(func() { close(gone) })
+			},
+			NotifFn: func(_ *store.EventStore, e store.Event) {
+				select {
+				case events <- e:
+				case <-gone:
+				}
+			},
+		}
+		defer func() {
+			goneOnce.Do(func() { close(gone) })
+			s.Unregister(l)
+		}()
+		if err := s.Subscribe(l, from); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			msg := message{}
+			select {
+			case e := <-events:
+				msg.Event = &e
+			case <-heartbeat.C:
+			case <-gone:
+				return
+			case <-r.Context().Done():
+				return
+			}
+			msg.LastID = s.LastID()
+			if err := enc.Encode(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// Status is how far a follower is behind its leader
+type Status struct {
+	Leader    string
+	Connected bool
+	// LeaderLastID is the last event the leader reported having
+	LeaderLastID int
+	// AppliedID is the last event the follower applied
+	AppliedID int
+	// Lag is the number of events the follower is behind, as of LastContact
+	Lag         int
+	LastContact time.Time
+	LastError   string `json:",omitempty"`
+}
+
+// Follower tails the replication stream of a leader into a read-only store
+type Follower struct {
+	store  *store.EventStore
+	leader string
+	// token is the admin token of the leader, sent with every request
+	token  string
+	client *http.Client
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewFollower returns a follower of the go-chess at leader, as in http://primary:8080,
+// token is the leader's admin token, "" if it takes none
+func NewFollower(s *store.EventStore, leader, token string) *Follower {
+	return &Follower{
+		store:  s,
+		leader: leader,
+		token:  token,
+		client: &http.Client{},
+		status: Status{Leader: leader, LeaderLastID: -1, AppliedID: s.LastID()},
+	}
+}
+
+// Run follows the leader until ctx is done, reconnecting whenever the stream breaks
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		err := f.follow(ctx)
+		f.update(func(s *Status) {
+			s.Connected = false
+			if err != nil {
+				s.LastError = err.Error()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("replication: lost the leader, reconnecting:", err)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *Follower) follow(ctx context.Context) error {
+	// cancelled on the way out so the reader below doesn't outlive the connection
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	url := fmt.Sprintf("%s/replication?from=%d", f.leader, f.store.LastID())
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader answered %s", resp.Status)
+	}
+	f.update(func(s *Status) {
+		s.Connected = true
+		s.LastError = ""
+	})
+
+	// a leader that goes quiet for longer than its heartbeats is gone
+	lines := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = errors.New("the leader closed the stream")
+		}
+		errs <- err
+	}()
+	for {
+		select {
+		case line := <-lines:
+			if err := f.apply(line); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		case <-time.After(requestTimeout):
+			return fmt.Errorf("no word from the leader in %s", requestTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *Follower) apply(line []byte) error {
+	var msg message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return err
+	}
+	if msg.Event != nil && msg.Event.Id > f.store.LastID() {
+		if err := f.store.Replicate(*msg.Event); err != nil {
+			return err
+		}
+	}
+	applied := f.store.LastID()
+	f.update(func(s *Status) {
+		s.LeaderLastID = msg.LastID
+		s.AppliedID = applied
+		s.Lag = msg.LastID - applied
+		if s.Lag < 0 {
+			s.Lag = 0
+		}
+		s.LastContact = time.Now()
+	})
+	return nil
+}
+
+func (f *Follower) update(fn func(s *Status)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.status)
+}
+
+// Status returns how far the follower is behind
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
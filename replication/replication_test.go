@@ -0,0 +1,94 @@
+package replication
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	leader := store.NewEventStore()
+	leader.Run()
+	ctx := context.Background()
+	leader.Persist(ctx, store.Event{AggregateID: "some game", EventType: 1})
+	srv := httptest.NewServer(Handler(leader))
+	defer srv.Close()
+
+	replica := store.NewEventStore()
+	replica.SetReadOnly()
+	replica.Run()
+	var notified []store.Event
+	done := make(chan struct{})
+	replica.Register(store.NewEventHandler(func(_ *store.EventStore, e store.Event) {
+		notified = append(notified, e)
+		if len(notified) == 3 {
+			close(done)
+		}
+	}))
+
+	f := NewFollower(replica, srv.URL, "")
+	followCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go f.Run(followCtx)
+
+	leader.Persist(ctx, store.Event{AggregateID: "some game", EventType: 2})
+	leader.Persist(ctx, store.Event{AggregateID: "other game", EventType: 1})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the replica's listeners to be notified but got", notified)
+	}
+	if !reflect.DeepEqual(replica.Events(), leader.Events()) {
+		t.Error("expected the replica to hold", leader.Events(), "but got", replica.Events())
+	}
+	eventually(t, func() bool {
+		s := f.Status()
+		return s.Connected && s.AppliedID == 2 && s.LeaderLastID == 2 && s.Lag == 0
+	})
+
+	if _, err := replica.Persist(ctx, store.Event{AggregateID: "some game", EventType: 1}); err != store.ErrReadOnly {
+		t.Error("expected the replica to refuse to persist but got", err)
+	}
+}
+
+func TestFollowerResumes(t *testing.T) {
+	leader := store.NewEventStore()
+	leader.Run()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		leader.Persist(ctx, store.Event{AggregateID: "some game", EventType: 1})
+	}
+
+	// the replica already holds the first event, it only asks for the rest
+	replica := store.NewEventStore()
+	replica.Import(leader.Events()[0])
+	replica.SetReadOnly()
+
+	srv := httptest.NewServer(Handler(leader))
+	defer srv.Close()
+	followCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go NewFollower(replica, srv.URL, "").Run(followCtx)
+
+	eventually(t, func() bool {
+		return replica.LastID() == 2
+	})
+	if !reflect.DeepEqual(replica.Events(), leader.Events()) {
+		t.Error("expected the replica to hold", leader.Events(), "but got", replica.Events())
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// EventArchived is the type of the event recording that an aggregate was archived
+const EventArchived = -3
+
+// ArchiveAggregateID is the stream archive events are kept in
+const ArchiveAggregateID = "$archive"
+
+// ErrArchived is returned when appending to an aggregate that was archived
+var ErrArchived = errors.New("aggregate is archived")
+
+// Archive is the data of EventArchived. The events in EventIDs are dropped
+// from the log when the store is compacted, Summary is what the application
+// keeps of them instead.
+type Archive struct {
+	AggregateID string
+	EventIDs    []int
+	Summary     json.RawMessage
+}
+
+// ArchiveOf returns the archive recorded by e, false if e isn't an archive event
+func ArchiveOf(e Event) (Archive, bool) {
+	var a Archive
+	if e.AggregateID != ArchiveAggregateID || e.EventType != EventArchived {
+		return a, false
+	}
+	if err := json.Unmarshal([]byte(e.EventData), &a); err != nil {
+		log.Println("store: skipping unreadable archive", e, err)
+		return a, false
+	}
+	return a, true
+}
+
+// Compacter is a Backend that can drop events from its log
+type Compacter interface {
+	// Compact rewrites the log with only the events keep returns true for
+	Compact(keep func(Event) bool) error
+}
+
+func (store *EventStore) replayArchive(ev Event) {
+	if a, ok := ArchiveOf(ev); ok {
+		store.archived[a.AggregateID] = true
+	}
+}
+
+// Archived tells if an aggregate was archived
+func (store *EventStore) Archived(aggregateID string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.archived[aggregateID]
+}
+
+// Archive records summary as what's kept of an aggregate once its events
+// are compacted away and returns the archive event. The aggregate takes no
+// more events after that.
+// expectedVersion is the version of the aggregate summary was made from, if
+// the aggregate moved on since it returns a *ConflictError so no event is
+// compacted away without being summed up. AnyVersion skips the check.
+func (store *EventStore) Archive(aggregateID string, expectedVersion int, summary interface{}) (Event, error) {
+	if store.readOnly {
+		return Event{}, ErrReadOnly
+	}
+	if IsSystemAggregate(aggregateID) {
+		return Event{}, fmt.Errorf("can't archive the store's own aggregate %s", aggregateID)
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return Event{}, err
+	}
+	store.writeSem <- struct{}{}
+	defer func() { <-store.writeSem }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.archived[aggregateID] {
+		return Event{}, ErrArchived
+	}
+	stream, err := store.backend.ReadStream(aggregateID, 0)
+	if err != nil {
+		return Event{}, err
+	}
+	if expectedVersion != AnyVersion && expectedVersion != len(stream) {
+		return Event{}, &ConflictError{AggregateID: aggregateID, Expected: expectedVersion, Actual: len(stream)}
+	}
+	a := Archive{AggregateID: aggregateID, EventIDs: make([]int, len(stream)), Summary: data}
+	for i, ev := range stream {
+		a.EventIDs[i] = ev.Id
+	}
+	return store.appendLocked(Event{
+		AggregateID: ArchiveAggregateID,
+		EventType:   EventArchived,
+		EventData:   EncodePayload(a),
+	}, AnyVersion, true)
+}
+
+// archivedIDs returns the Ids of every archived event in events
+func archivedIDs(events []Event) map[int]bool {
+	ids := map[int]bool{}
+	for _, ev := range events {
+		if a, ok := ArchiveOf(ev); ok {
+			for _, id := range a.EventIDs {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// Compact drops the events of archived aggregates from the log and returns
+// how many it dropped. Ids aren't reused and the hash chain still verifies,
+// the archive events account for the gaps.
+func (store *EventStore) Compact() (int, error) {
+	c, ok := store.rawBackend().(Compacter)
+	if !ok {
+		return 0, fmt.Errorf("the store's backend can't be compacted")
+	}
+	store.writeSem <- struct{}{}
+	defer func() { <-store.writeSem }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return 0, ErrClosed
+	}
+	archives, err := store.backend.ReadStream(ArchiveAggregateID, 0)
+	if err != nil {
+		return 0, err
+	}
+	ids := archivedIDs(archives)
+	events, err := store.backend.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for _, ev := range events {
+		if ids[ev.Id] {
+			dropped++
+		}
+	}
+	// rewriting the log is costly, it's left alone unless there's something to drop
+	if dropped == 0 {
+		return 0, nil
+	}
+	err = c.Compact(func(ev Event) bool {
+		return !ids[ev.Id]
+	})
+	if err != nil {
+		return 0, err
+	}
+	// the games are served from their archives now
+	for aggregateID := range store.archived {
+		store.DropSnapshots(aggregateID, 0)
+	}
+	return dropped, nil
+}
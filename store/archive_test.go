@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchiveAndCompact(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"finished", "on", "finished", "on"} {
+		if _, err := s.Persist(ctx, Event{AggregateID: id, EventType: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Archive("finished", AnyVersion, "the summary"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Persist(ctx, Event{AggregateID: "finished", EventType: 1}); err != ErrArchived {
+		t.Error("expected an archived game to take no more events but got", err)
+	}
+
+	dropped, err := s.Compact()
+	if err != nil || dropped != 2 {
+		t.Fatal("expected to drop the 2 archived events but dropped", dropped, err)
+	}
+	if _, err := s.Persist(ctx, Event{AggregateID: "on", EventType: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.rawBackend().(*FileBackend).Close()
+
+	restarted, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, ev := range restarted.Events() {
+		ids = append(ids, ev.Id)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3, 4, 5}) {
+		t.Error("expected the archived events to stay compacted away but the log holds", ids)
+	}
+	if !restarted.Archived("finished") || restarted.Archived("on") {
+		t.Error("expected only the finished game to be archived after a restart")
+	}
+	report, err := restarted.VerifyChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken != nil || report.Verified != 4 || report.Compacted != 2 {
+		t.Error("expected 4 verified events and 2 compacted but got", report, report.Broken)
+	}
+
+	// a gap the archives don't account for still breaks the chain
+	events := restarted.Events()
+	missing := append(append([]Event{}, events[:1]...), events[2:]...)
+	if report := VerifyChain(missing); report.Broken == nil || report.Broken.EventID != 4 {
+		t.Error("expected dropping event 3 to break the chain at event 4 but got", report.Broken)
+	}
+}
+
+func TestFileLogRewriteRecovers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	for i := 0; i < 4; i++ {
+		ev := Event{Id: i, AggregateID: "some game", EventType: 1}
+		l.Append(ev)
+		events = append(events, ev)
+	}
+	l.Close()
+
+	// a rewrite that crashed before it was committed leaves the old log
+	if err := l.writeRewrite(events[2:]); err != nil {
+		t.Fatal(err)
+	}
+	l, replayed, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, events) {
+		t.Error("expected the old log", events, "but got", replayed)
+	}
+
+	// one that crashed after it was committed is finished
+	l.Close()
+	if err := l.writeRewrite(events[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.mark(rewriteCommitted); err != nil {
+		t.Fatal(err)
+	}
+	_, replayed, err = OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, events[2:]) {
+		t.Error("expected the rewritten log", events[2:], "but got", replayed)
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) != segmentExt {
+			t.Error("expected only segments to be left but found", info.Name())
+		}
+	}
+}
+
+func TestArchiveExpectsTheSummedUpVersion(t *testing.T) {
+	s := NewEventStore()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := s.Persist(ctx, Event{AggregateID: "finished", EventType: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version := len(s.ReadStream("finished", 0))
+	// a rollback lands between making the summary and archiving
+	if _, err := s.Persist(ctx, Event{AggregateID: "finished", EventType: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Archive("finished", version, "the summary")
+	if conflict, ok := err.(*ConflictError); !ok || conflict.Expected != 2 || conflict.Actual != 3 {
+		t.Fatal("expected archiving a stale summary to conflict but got", err)
+	}
+	if s.Archived("finished") {
+		t.Error("expected the game not to be archived")
+	}
+	if _, err := s.Archive("finished", version+1, "the summary"); err != nil {
+		t.Error("expected a summary of the current version to be archived but got", err)
+	}
+}
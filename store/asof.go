@@ -0,0 +1,70 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// AsOf returns the log as it was once the event with the given Id was
+// appended, so replaying it rebuilds the state of that moment, rollbacks
+// appended by then included.
+// Events of aggregates that were archived since and compacted away are stood
+// in for by a copy of their archive event, cut down to the Ids up to id and
+// placed at the last of them. It's up to the application to sum up an
+// aggregate from the part of its archive it gets.
+func (store *EventStore) AsOf(id int) []Event {
+	all := store.Events()
+	kept := make(map[int]bool, len(all))
+	events := []Event{}
+	for _, ev := range all {
+		kept[ev.Id] = true
+		if ev.Id <= id {
+			events = append(events, ev)
+		}
+	}
+	for _, ev := range all {
+		if ev.Id <= id {
+			continue
+		}
+		a, ok := ArchiveOf(ev)
+		if !ok {
+			continue
+		}
+		var ids []int
+		for _, archivedID := range a.EventIDs {
+			if archivedID <= id && !kept[archivedID] {
+				ids = append(ids, archivedID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		a.EventIDs = ids
+		stand := ev
+		stand.Id = ids[len(ids)-1]
+		stand.EventData = EncodePayload(a)
+		stand.Metadata = Metadata{}
+		stand.PrevHash, stand.Hash = "", ""
+		events = append(events, stand)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Id < events[j].Id
+	})
+	return events
+}
+
+// IDAsOf returns the Id of the last event appended by t, -1 if there's none.
+// Events are taken in append order and the first one stamped after t ends
+// the search, even if a later one has an earlier timestamp.
+// Events that were compacted away took their timestamps with them, the Id
+// returned is that of the last event by t that's still in the log.
+func (store *EventStore) IDAsOf(t time.Time) int {
+	id := -1
+	for _, ev := range store.Events() {
+		if ev.Metadata.Timestamp.After(t) {
+			break
+		}
+		id = ev.Id
+	}
+	return id
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAsOf(t *testing.T) {
+	s := NewEventStore()
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	s.clock = func() time.Time { return now }
+	// event i is appended i+1 minutes in
+	ctx := context.Background()
+	for _, id := range []string{"finished", "on", "finished", "on"} {
+		now = now.Add(time.Minute)
+		if _, err := s.Persist(ctx, Event{AggregateID: id, EventType: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.Archive("finished", AnyVersion, "the summary"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.Persist(ctx, Event{AggregateID: "on", EventType: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(events []Event) []int {
+		ids := []int{}
+		for _, ev := range events {
+			ids = append(ids, ev.Id)
+		}
+		return ids
+	}
+	events := s.AsOf(2)
+	if !reflect.DeepEqual(ids(events), []int{1, 2}) {
+		t.Fatal("expected event 1 and the compacted game's archive at 2 but got", events)
+	}
+	a, ok := ArchiveOf(events[1])
+	if !ok || a.AggregateID != "finished" || !reflect.DeepEqual(a.EventIDs, []int{0, 2}) {
+		t.Error("expected the archive cut down to events 0 and 2 but got", a, ok)
+	}
+	if events := s.AsOf(0); len(events) != 1 {
+		t.Error("expected only the archive standing in for event 0 but got", events)
+	} else if a, _ := ArchiveOf(events[0]); !reflect.DeepEqual(a.EventIDs, []int{0}) {
+		t.Error("expected the archive cut down to event 0 but got", a)
+	}
+	if events := s.AsOf(4); !reflect.DeepEqual(ids(events), []int{1, 3, 4}) || events[2].Hash == "" {
+		t.Error("expected the log up to the archive itself but got", events)
+	}
+	if events := s.AsOf(-1); len(events) != 0 {
+		t.Error("expected nothing before the first event but got", events)
+	}
+
+	// events 0 and 2 were compacted away with their timestamps
+	for minutes, want := range map[int]int{0: -1, 1: -1, 2: 1, 3: 1, 4: 3, 100: 5} {
+		if id := s.IDAsOf(start.Add(time.Duration(minutes) * time.Minute)); id != want {
+			t.Errorf("expected event %d as of %d minutes in but got %d", want, minutes, id)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package store
+
+// Backend is where an EventStore keeps its events.
+// The store never calls Append concurrently with any other method,
+// reads may run concurrently with each other.
+type Backend interface {
+	// Append adds an event, which already has its Id assigned, to the end of the log
+	Append(ev Event) error
+	// ReadAll returns every event in the order it was appended
+	ReadAll() ([]Event, error)
+	// ReadStream returns the events of a single aggregate,
+	// skipping the first fromVersion of them
+	ReadStream(aggregateID string, fromVersion int) ([]Event, error)
+	// LastID returns the Id of the last appended event or -1 if there are none
+	LastID() int
+}
+
+// MemoryBackend keeps events in a slice, they're gone once the process exits.
+// Every aggregate's stream is indexed separately so reading it doesn't
+// depend on how many events other aggregates have.
+type MemoryBackend struct {
+	events  []Event
+	streams map[string][]Event
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{streams: map[string][]Event{}}
+}
+
+func (b *MemoryBackend) Append(ev Event) error {
+	b.events = append(b.events, ev)
+	b.streams[ev.AggregateID] = append(b.streams[ev.AggregateID], ev)
+	return nil
+}
+
+func (b *MemoryBackend) ReadAll() ([]Event, error) {
+	// cap the slice so appending to it can't overwrite later events
+	return b.events[:len(b.events):len(b.events)], nil
+}
+
+func (b *MemoryBackend) ReadStream(aggregateID string, fromVersion int) ([]Event, error) {
+	stream := b.streams[aggregateID]
+	if fromVersion < 0 {
+		fromVersion = 0
+	}
+	if fromVersion >= len(stream) {
+		return nil, nil
+	}
+	return stream[fromVersion:len(stream):len(stream)], nil
+}
+
+func (b *MemoryBackend) Compact(keep func(Event) bool) error {
+	events := b.events
+	b.events, b.streams = nil, map[string][]Event{}
+	for _, ev := range events {
+		if keep(ev) {
+			b.Append(ev)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) LastID() int {
+	if len(b.events) == 0 {
+		return -1
+	}
+	return b.events[len(b.events)-1].Id
+}
@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scottcarol/go-chess/store"
+	"github.com/scottcarol/go-chess/store/storetest"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	storetest.TestBackend(t, func(t *testing.T) store.Backend {
+		return store.NewMemoryBackend()
+	})
+}
+
+func TestFileBackend(t *testing.T) {
+	// the backends are torn down once the whole suite ran
+	var backends []*store.FileBackend
+	var dirs []string
+	defer func() {
+		for i := range backends {
+			backends[i].Close()
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	storetest.TestBackend(t, func(t *testing.T) store.Backend {
+		dir, err := ioutil.TempDir("", "go-chess-store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := store.OpenFileBackend(dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		backends, dirs = append(backends, b), append(dirs, dir)
+		return b
+	})
+}
@@ -0,0 +1,57 @@
+package store
+
+import "sort"
+
+// EventByID returns the event with the given Id
+func (store *EventStore) EventByID(id int) (Event, bool) {
+	events := store.Events()
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].Id >= id
+	})
+	if i == len(events) || events[i].Id != id {
+		return Event{}, false
+	}
+	return events[i], true
+}
+
+// CausalChain returns the event with the given Id preceded by the events that
+// led to it, starting from the one nothing caused
+func (store *EventStore) CausalChain(id int) []Event {
+	var chain []Event
+	for {
+		ev, ok := store.EventByID(id)
+		if !ok {
+			break
+		}
+		chain = append([]Event{ev}, chain...)
+		if ev.Metadata.CausationID == nil {
+			break
+		}
+		id = *ev.Metadata.CausationID
+	}
+	return chain
+}
+
+// Consequences returns the events the event with the given Id caused,
+// directly or through other events, in the order they were appended.
+// Causes can be in another aggregate than what they caused, a schedule in the
+// scheduler's stream fires an event in a game's, so the whole log after the
+// event is read.
+func (store *EventStore) Consequences(id int) []Event {
+	events := store.Events()
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].Id >= id
+	})
+	if i == len(events) || events[i].Id != id {
+		return nil
+	}
+	caused := map[int]bool{id: true}
+	var consequences []Event
+	for _, e := range events[i+1:] {
+		if e.Metadata.CausationID != nil && caused[*e.Metadata.CausationID] {
+			caused[e.Id] = true
+			consequences = append(consequences, e)
+		}
+	}
+	return consequences
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMetadataIsFilledIn(t *testing.T) {
+	s := NewEventStore()
+	now := time.Date(2019, 10, 31, 14, 3, 0, 0, time.FixedZone("somewhere", 3600))
+	s.clock = func() time.Time { return now }
+
+	request, _ := s.Persist(context.Background(), Event{AggregateID: "some game", Metadata: Metadata{Actor: "alice"}})
+	if !request.Metadata.Timestamp.Equal(now) || request.Metadata.Timestamp.Location() != time.UTC {
+		t.Error("expected the event to be stamped with the time in UTC but got", request.Metadata)
+	}
+	if request.Metadata.CorrelationID != "0" || request.Metadata.CausationID != nil {
+		t.Error("expected a request to start its own correlation but got", request.Metadata)
+	}
+
+	success, _ := s.Persist(context.Background(), Event{AggregateID: "some game", Metadata: CausedBy(request)})
+	m := success.Metadata
+	if m.Actor != "alice" || m.CorrelationID != "0" || m.CausationID == nil || *m.CausationID != request.Id {
+		t.Error("expected the actor and correlation of", request, "and to be caused by it but got", m)
+	}
+}
+
+func TestCausalChain(t *testing.T) {
+	s := NewEventStore()
+	ctx := context.Background()
+
+	request, _ := s.Persist(ctx, Event{AggregateID: "some game"})
+	s.Persist(ctx, Event{AggregateID: "other game"})
+	success, _ := s.Persist(ctx, Event{AggregateID: "some game", Metadata: CausedBy(request)})
+	unrelated, _ := s.Persist(ctx, Event{AggregateID: "some game"})
+	win, _ := s.Persist(ctx, Event{AggregateID: "some game", Metadata: CausedBy(success)})
+
+	chain := s.CausalChain(win.Id)
+	if len(chain) != 3 || chain[0].Id != request.Id || chain[1].Id != success.Id || chain[2].Id != win.Id {
+		t.Error("expected the chain from", request, "to", win, "but got", chain)
+	}
+
+	consequences := s.Consequences(request.Id)
+	if len(consequences) != 2 || consequences[0].Id != success.Id || consequences[1].Id != win.Id {
+		t.Error("expected", request, "to lead to", success, "and", win, "but got", consequences, "and not", unrelated)
+	}
+
+	if chain := s.CausalChain(100); len(chain) != 0 {
+		t.Error("expected no chain for an unknown event but got", chain)
+	}
+}
+
+func TestConsequencesAcrossAggregates(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewEventStore()
+	s.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	request, _ := s.Persist(ctx, Event{AggregateID: "some game"})
+	timeout := Event{AggregateID: "some game", EventType: 1, Metadata: CausedBy(request)}
+	if err := s.Schedule(ctx, "timeout", now.Add(time.Minute), timeout); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := s.LastID()
+	now = now.Add(time.Minute)
+	s.fireDue()
+	fired := s.ReadStream("some game", 0)
+	if len(fired) != 2 {
+		t.Fatal("expected the timeout to fire but got", fired)
+	}
+	s.Persist(ctx, Event{AggregateID: "other game", Metadata: CausedBy(fired[1])})
+
+	consequences := s.Consequences(request.Id)
+	if len(consequences) != 3 || consequences[0].Id != scheduled || consequences[1].Id != fired[1].Id || consequences[2].AggregateID != "other game" {
+		t.Error("expected", request, "to lead to its schedule, the timeout it fired and what that caused but got", consequences)
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// ComputeHash returns the hash of everything in the event but its Hash,
+// PrevHash included, so it also covers every event before it.
+// It's taken over the event as stored, before any upcasting.
+func (ev Event) ComputeHash() string {
+	ev.Hash = ""
+	data, err := json.Marshal(ev)
+	if err != nil {
+		panic(fmt.Sprintf("store: can't encode event %v: %v", ev, err))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainError is the first link found broken in the hash chain
+type ChainError struct {
+	EventID int
+	Reason  string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("event %d: %s", e.EventID, e.Reason)
+}
+
+// ChainReport is the outcome of walking the hash chain
+type ChainReport struct {
+	// Verified is the number of events whose hash checked out
+	Verified int
+	// Unhashed is the number of events at the front of the log without a
+	// hash. There's nothing to check them against, they're either from
+	// before the log was chained or had their hashes stripped.
+	Unhashed int
+	// Compacted is the number of archived events that were dropped from the log
+	Compacted int
+	Broken    *ChainError `json:",omitempty"`
+}
+
+// VerifyChain walks events in append order and reports the first one that
+// doesn't match its hash or doesn't link to the hash of the event before it.
+// Events before the first hashed one are counted as unhashed rather than
+// broken, it's up to the caller to accept them. Past it every event has to
+// be hashed.
+// An event may link to one that was compacted away, as long as every event
+// missing before it was archived.
+func VerifyChain(events []Event) ChainReport {
+	var (
+		report   ChainReport
+		prev     *Event
+		archived = archivedIDs(events)
+	)
+	// compacted tells if the events missing before events[i] were all
+	// archived, and counts them if they were
+	compacted := func(i int) bool {
+		ev, from := events[i], 0
+		if i > 0 {
+			from = events[i-1].Id + 1
+		}
+		if from >= ev.Id {
+			return false
+		}
+		for id := from; id < ev.Id; id++ {
+			if !archived[id] {
+				return false
+			}
+		}
+		report.Compacted += ev.Id - from
+		return true
+	}
+	for i := range events {
+		ev := &events[i]
+		if prev == nil && ev.Hash == "" {
+			report.Unhashed++
+			continue
+		}
+		switch {
+		case ev.Hash == "":
+			report.Broken = &ChainError{EventID: ev.Id, Reason: "missing hash"}
+		case prev != nil && ev.PrevHash != prev.Hash && !compacted(i):
+			report.Broken = &ChainError{EventID: ev.Id, Reason: fmt.Sprintf("links to %.12s but event %d is %.12s", ev.PrevHash, prev.Id, prev.Hash)}
+		case prev == nil && ev.PrevHash != "" && !compacted(i):
+			report.Broken = &ChainError{EventID: ev.Id, Reason: "links to an event that isn't hashed"}
+		case ev.ComputeHash() != ev.Hash:
+			report.Broken = &ChainError{EventID: ev.Id, Reason: "content doesn't match its hash"}
+		}
+		if report.Broken != nil {
+			return report
+		}
+		report.Verified++
+		prev = ev
+	}
+	return report
+}
+
+// VerifyChain walks the hash chain of every event in the store
+func (store *EventStore) VerifyChain() (ChainReport, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	events, err := store.rawBackend().ReadAll()
+	if err != nil {
+		return ChainReport{}, err
+	}
+	return VerifyChain(events), nil
+}
+
+// rawBackend returns the backend without upcasting,
+// the events it returns are the ones that were hashed
+func (store *EventStore) rawBackend() Backend {
+	if b, ok := store.backend.(*typedBackend); ok {
+		return b.Backend
+	}
+	return store.backend
+}
+
+// chain links an event about to be appended to the last one, it must be called holding mu
+func (store *EventStore) chain(ev Event) (Event, error) {
+	last := store.backend.LastID()
+	ev.PrevHash = ""
+	if last >= 0 {
+		events, err := store.rawBackend().ReadAll()
+		if err != nil {
+			return ev, err
+		}
+		ev.PrevHash = events[len(events)-1].Hash
+	}
+	ev.Hash = ev.ComputeHash()
+	return ev, nil
+}
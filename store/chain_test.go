@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// events from before the log was chained
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(Event{Id: 0, AggregateID: "some game", EventType: 1, Version: 1})
+	l.Close()
+
+	s, err := NewFileEventStore(dir, greetingTypes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		ev := Event{AggregateID: "some game", EventType: 1, EventData: EncodePayload(greeting{Name: name})}
+		if _, err := s.Persist(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.rawBackend().(*FileBackend).Close()
+
+	// the hashes have to survive being written out and read back
+	restarted, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := restarted.VerifyChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken != nil || report.Verified != 3 || report.Unhashed != 1 {
+		t.Fatal("expected 3 verified events and 1 unhashed but got", report, report.Broken)
+	}
+
+	events := restarted.Events()
+	tampered := make([]Event, len(events))
+	copy(tampered, events)
+	tampered[2].EventData = EncodePayload(greeting{Name: "mallory"})
+	if report := VerifyChain(tampered); report.Broken == nil || report.Broken.EventID != 2 {
+		t.Error("expected the edited event 2 to break the chain but got", report.Broken)
+	}
+
+	// rehashing the edited event doesn't cover it up, the next one still links to the old hash
+	tampered[2].Hash = tampered[2].ComputeHash()
+	if report := VerifyChain(tampered); report.Broken == nil || report.Broken.EventID != 3 {
+		t.Error("expected event 3 to break the chain but got", report.Broken)
+	}
+
+	dropped := append(append([]Event{}, events[:2]...), events[3:]...)
+	if report := VerifyChain(dropped); report.Broken == nil || report.Broken.EventID != 3 {
+		t.Error("expected dropping event 2 to break the chain at event 3 but got", report.Broken)
+	}
+}
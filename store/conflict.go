@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// AnyVersion can be passed as an expected version to skip the concurrency check
+const AnyVersion = -1
+
+// ConflictError is returned when an event is persisted against a version of its
+// aggregate that is no longer the current one
+type ConflictError struct {
+	AggregateID string
+	Expected    int
+	Actual      int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("aggregate %s is at version %d, expected %d", e.AggregateID, e.Actual, e.Expected)
+}
+
+// VersionedPersister persists events expecting their aggregate to be at Version
+// and moves Version forward with every event it persists
+type VersionedPersister struct {
+	Store   *EventStore
+	Version int
+}
+
+func (p *VersionedPersister) Persist(e Event) error {
+	if _, err := p.Store.PersistExpected(context.Background(), e, p.Version); err != nil {
+		return err
+	}
+	p.Version++
+	return nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxDeadLetters is how many dead letters are kept, older ones are discarded
+const maxDeadLetters = 1000
+
+// DeadLetter is an event a listener didn't handle
+type DeadLetter struct {
+	Listener string
+	Event    Event
+	Reason   string
+	Time     time.Time
+}
+
+func (d DeadLetter) String() string {
+	return fmt.Sprintf("DeadLetter<Listener: %s, Event: %v, Reason: %s, Time: %s>",
+		d.Listener, d.Event, d.Reason, d.Time.Format(time.RFC3339))
+}
+
+// DeadLetters returns the most recent dead letters, oldest first
+func (store *EventStore) DeadLetters() []DeadLetter {
+	store.deadMu.Lock()
+	defer store.deadMu.Unlock()
+	return append([]DeadLetter{}, store.deadLetters...)
+}
+
+func (store *EventStore) addDeadLetter(l *EventListener, e Event, reason string) {
+	store.AddDeadLetter(l.name(), e, reason)
+}
+
+// AddDeadLetter records an event the named listener gave up on
+func (store *EventStore) AddDeadLetter(listener string, e Event, reason string) {
+	store.deadMu.Lock()
+	defer store.deadMu.Unlock()
+	if len(store.deadLetters) == maxDeadLetters {
+		store.deadLetters = append(store.deadLetters[:0], store.deadLetters[1:]...)
+	}
+	store.deadLetters = append(store.deadLetters, DeadLetter{
+		Listener: listener,
+		Event:    e,
+		Reason:   reason,
+		Time:     time.Now().UTC(),
+	})
+}
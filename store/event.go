@@ -1,15 +1,66 @@
 package store
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type Event struct {
 	Id          int
 	AggregateID string
 	EventData   string
 	EventType   int
+	// TypeName and SchemaVersion are set by stores with a TypeRegistry,
+	// they identify the type and the format of EventData
+	TypeName      string
+	SchemaVersion int
+	// Version is the position of the event in its aggregate's stream, starting at 1
+	Version  int
+	Metadata Metadata
+	// PrevHash is the Hash of the event before it and Hash is ComputeHash of
+	// this one, they're set by the store and chain the whole log together so
+	// it can't be edited without it showing (see VerifyChain)
+	PrevHash string `json:",omitempty"`
+	Hash     string `json:",omitempty"`
+}
+
+// Metadata tells when an event happened, who asked for it and what led to it.
+// Timestamp and CorrelationID are filled in by the store if they're empty.
+type Metadata struct {
+	Timestamp time.Time
+	// Actor is the session that requested the event or the one that led to it
+	Actor string
+	// CorrelationID is shared by every event that stems from the same request,
+	// it's the Id of the first of them
+	CorrelationID string
+	// CausationID is the Id of the event that triggered this one, nil if none did
+	CausationID *int
+	// IdempotencyKey is set by clients on the commands they submit, a command
+	// with a key already used for its aggregate isn't appended again (see Persist)
+	IdempotencyKey string `json:",omitempty"`
+}
+
+// CausedBy returns the metadata of an event triggered by cause
+func CausedBy(cause Event) Metadata {
+	id := cause.Id
+	return Metadata{
+		Actor:         cause.Metadata.Actor,
+		CorrelationID: cause.Metadata.CorrelationID,
+		CausationID:   &id,
+	}
+}
+
+func (m Metadata) String() string {
+	cause := "none"
+	if m.CausationID != nil {
+		cause = strconv.Itoa(*m.CausationID)
+	}
+	return fmt.Sprintf("Metadata<Timestamp: %s, Actor: %s, CorrelationID: %s, CausationID: %s>",
+		m.Timestamp.Format(time.RFC3339Nano), m.Actor, m.CorrelationID, cause)
 }
 
 func (ev Event) String() string {
-	return fmt.Sprintf("Event<Id: %d, AggregateID: %s, EventData: %s, EventType: %d>",
-		ev.Id, ev.AggregateID, ev.EventData, ev.EventType)
+	return fmt.Sprintf("Event<Id: %d, AggregateID: %s, Version: %d, EventData: %s, EventType: %d>",
+		ev.Id, ev.AggregateID, ev.Version, ev.EventData, ev.EventType)
 }
@@ -0,0 +1,508 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	segmentExt         = ".seg"
+	rewriteExt         = ".new"
+	rewriteCommitted   = "rewrite-committed"
+	rewriteRenaming    = "rewrite-renaming"
+	recordHeaderSize   = 8
+	maxRecordSize      = 1 << 24
+	defaultSegmentSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// FileBackend is a Backend that writes every event to a FileLog and serves
+// reads from memory, the log is only read back when the backend is opened.
+type FileBackend struct {
+	*MemoryBackend
+	log *FileLog
+}
+
+func OpenFileBackend(dir string) (*FileBackend, error) {
+	l, events, err := OpenFileLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	mem := NewMemoryBackend()
+	for _, ev := range events {
+		mem.Append(ev)
+	}
+	return &FileBackend{MemoryBackend: mem, log: l}, nil
+}
+
+func (b *FileBackend) Append(ev Event) error {
+	if err := b.log.Append(ev); err != nil {
+		return err
+	}
+	return b.MemoryBackend.Append(ev)
+}
+
+// Compact rewrites the log with only the events keep returns true for
+func (b *FileBackend) Compact(keep func(Event) bool) error {
+	var kept []Event
+	for _, ev := range b.events {
+		if keep(ev) {
+			kept = append(kept, ev)
+		}
+	}
+	if err := b.log.Rewrite(kept); err != nil {
+		return err
+	}
+	mem := NewMemoryBackend()
+	for _, ev := range kept {
+		mem.Append(ev)
+	}
+	b.MemoryBackend = mem
+	return nil
+}
+
+func (b *FileBackend) Close() error {
+	return b.log.Close()
+}
+
+// readOnlyFileBackend holds the events of a log read with ReadFileLog,
+// it never writes to the log's dir
+type readOnlyFileBackend struct {
+	*MemoryBackend
+	dir string
+}
+
+func (b *readOnlyFileBackend) Append(Event) error {
+	return ErrReadOnly
+}
+
+// FileLog is an append-only log of events split into segment files.
+// Every record is written as [length][crc32c][json event] and synced to disk
+// before Append returns.
+type FileLog struct {
+	dir         string
+	segmentSize int64
+	segment     *os.File
+	size        int64
+}
+
+// OpenFileLog opens (or creates) the log in dir and returns it together with
+// every event it holds. A truncated or corrupt record at the tail of the last
+// segment is what a crash mid-write leaves behind, so it's cut off rather
+// than reported as an error.
+func OpenFileLog(dir string) (*FileLog, []Event, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	l := &FileLog{dir: dir, segmentSize: defaultSegmentSize}
+	if err := l.finishRewrite(); err != nil {
+		return nil, nil, err
+	}
+
+	segments, err := l.segments()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var events []Event
+	for i, name := range segments {
+		last := i == len(segments)-1
+		evs, valid, err := readSegment(filepath.Join(dir, name))
+		events = append(events, evs...)
+		if err == nil {
+			continue
+		}
+		if err != errCorruptRecord {
+			return nil, nil, err
+		}
+		if !last {
+			return nil, nil, fmt.Errorf("segment %s: %v", name, err)
+		}
+		log.Printf("store: cutting off corrupt tail of segment %s at offset %d: %v", name, valid, err)
+		if err := os.Truncate(filepath.Join(dir, name), valid); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(segments) > 0 {
+		if err := l.openSegment(segments[len(segments)-1]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return l, events, nil
+}
+
+// CorruptTailError is a record at the end of the last segment that's cut
+// short or doesn't match its checksum, Offset is where it starts
+type CorruptTailError struct {
+	Segment string
+	Offset  int64
+}
+
+func (e *CorruptTailError) Error() string {
+	return fmt.Sprintf("segment %s: corrupt record at offset %d", e.Segment, e.Offset)
+}
+
+// ReadFileLog returns the events of the log in dir without changing anything
+// there, so it's safe to read a log a running server has open. Unlike
+// OpenFileLog it leaves a bad tail in place, the events before it are
+// returned with a *CorruptTailError. A log in the middle of a rewrite can't
+// be read, nor can one that's rewritten while it's read.
+func ReadFileLog(dir string) ([]Event, error) {
+	l := &FileLog{dir: dir}
+	for _, marker := range []string{rewriteCommitted, rewriteRenaming} {
+		marked, err := l.marked(marker)
+		if err != nil {
+			return nil, err
+		}
+		if marked {
+			return nil, errors.New("the log is being rewritten, try again once it's done")
+		}
+	}
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for i, name := range segments {
+		evs, valid, err := readSegment(filepath.Join(dir, name))
+		events = append(events, evs...)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			return nil, errors.New("the log was rewritten while it was read, try again")
+		case err != errCorruptRecord:
+			return nil, err
+		case i < len(segments)-1:
+			return nil, fmt.Errorf("segment %s: %v", name, err)
+		default:
+			return events, &CorruptTailError{Segment: name, Offset: valid}
+		}
+	}
+	return events, nil
+}
+
+// Append writes the event to the end of the log and syncs it to disk.
+func (l *FileLog) Append(ev Event) error {
+	rec, err := encodeRecord(ev)
+	if err != nil {
+		return err
+	}
+
+	if l.segment == nil || (l.size > 0 && l.size+int64(len(rec)) > l.segmentSize) {
+		if err := l.rotate(ev.Id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := l.segment.Write(rec); err != nil {
+		// don't leave half a record behind for the next append to follow
+		l.segment.Truncate(l.size)
+		return err
+	}
+	if err := l.segment.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(rec))
+	return nil
+}
+
+func encodeRecord(ev Event) ([]byte, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	rec := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(data, crcTable))
+	copy(rec[recordHeaderSize:], data)
+	return rec, nil
+}
+
+// Rewrite replaces every segment of the log with new ones holding events.
+// The new segments are written next to the old ones and only take their
+// place once they're all on disk, a crash before that leaves the old log
+// and a crash after it is finished when the log is opened again.
+func (l *FileLog) Rewrite(events []Event) error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	if err := l.writeRewrite(events); err != nil {
+		l.removeRewrite()
+		return err
+	}
+	if err := l.mark(rewriteCommitted); err != nil {
+		l.removeRewrite()
+		return err
+	}
+	if err := l.finishRewrite(); err != nil {
+		return err
+	}
+	segments, err := l.segments()
+	if err != nil || len(segments) == 0 {
+		return err
+	}
+	return l.openSegment(segments[len(segments)-1])
+}
+
+// writeRewrite writes events to new segments that aren't part of the log yet
+func (l *FileLog) writeRewrite(events []Event) error {
+	var (
+		f    *os.File
+		size int64
+	)
+	closeSegment := func() error {
+		if f == nil {
+			return nil
+		}
+		err := f.Sync()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		f = nil
+		return err
+	}
+	for _, ev := range events {
+		rec, err := encodeRecord(ev)
+		if err != nil {
+			closeSegment()
+			return err
+		}
+		if f == nil || (size > 0 && size+int64(len(rec)) > l.segmentSize) {
+			if err := closeSegment(); err != nil {
+				return err
+			}
+			name := fmt.Sprintf("%020d%s%s", ev.Id, segmentExt, rewriteExt)
+			if f, err = os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				return err
+			}
+			size = 0
+		}
+		if _, err := f.Write(rec); err != nil {
+			closeSegment()
+			return err
+		}
+		size += int64(len(rec))
+	}
+	if err := closeSegment(); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// finishRewrite completes a rewrite that was committed, or throws away the
+// new segments of one that wasn't. The old segments are all removed before
+// any new one is renamed, so they're never mixed up.
+func (l *FileLog) finishRewrite() error {
+	committed, err := l.marked(rewriteCommitted)
+	if err != nil {
+		return err
+	}
+	renaming, err := l.marked(rewriteRenaming)
+	if err != nil {
+		return err
+	}
+	if !committed && !renaming {
+		return l.removeRewrite()
+	}
+
+	if !renaming {
+		segments, err := l.segments()
+		if err != nil {
+			return err
+		}
+		for _, name := range segments {
+			if err := os.Remove(filepath.Join(l.dir, name)); err != nil {
+				return err
+			}
+		}
+		if err := l.mark(rewriteRenaming); err != nil {
+			return err
+		}
+	}
+	names, err := l.rewriteSegments()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(l.dir, name), filepath.Join(l.dir, strings.TrimSuffix(name, rewriteExt))); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+	for _, marker := range []string{rewriteCommitted, rewriteRenaming} {
+		if err := os.Remove(filepath.Join(l.dir, marker)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(l.dir)
+}
+
+// removeRewrite removes the new segments of a rewrite that wasn't committed
+func (l *FileLog) removeRewrite() error {
+	names, err := l.rewriteSegments()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(l.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *FileLog) rewriteSegments() ([]string, error) {
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), segmentExt+rewriteExt) {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// mark creates an empty marker file and syncs it to disk
+func (l *FileLog) mark(name string) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+func (l *FileLog) marked(name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(l.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *FileLog) Close() error {
+	if l.segment == nil {
+		return nil
+	}
+	err := l.segment.Close()
+	l.segment = nil
+	return err
+}
+
+func (l *FileLog) segments() ([]string, error) {
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), segmentExt) {
+			names = append(names, info.Name())
+		}
+	}
+	// names are zero padded so lexical order is log order
+	sort.Strings(names)
+	return names, nil
+}
+
+func (l *FileLog) openSegment(name string) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.segment, l.size = f, info.Size()
+	return nil
+}
+
+// rotate starts a new segment named after the id of its first event
+func (l *FileLog) rotate(firstID int) error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d%s", firstID, segmentExt)
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.segment, l.size = f, 0
+	return syncDir(l.dir)
+}
+
+// readSegment returns the events in a segment file and the offset up to which
+// the file is valid. The error is set if reading stopped before the end.
+func readSegment(path string) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		events []Event
+		offset int64
+		r      = bufio.NewReader(f)
+		header = make([]byte, recordHeaderSize)
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return events, offset, nil
+			}
+			return events, offset, errCorruptRecord
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return events, offset, errCorruptRecord
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return events, offset, errCorruptRecord
+		}
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return events, offset, errCorruptRecord
+		}
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return events, offset, errCorruptRecord
+		}
+		events = append(events, ev)
+		offset += int64(recordHeaderSize + size)
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "go-chess-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileLogReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, events, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Error("expected an empty log but received", events)
+	}
+	l.segmentSize = 200
+
+	var expected []Event
+	for i := 0; i < 10; i++ {
+		ev := Event{Id: i, AggregateID: "some game", EventData: "12-20", EventType: 1}
+		if err := l.Append(ev); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, ev)
+	}
+	l.Close()
+
+	if segments, _ := l.segments(); len(segments) < 2 {
+		t.Error("expected the log to be split into several segments but got", segments)
+	}
+
+	_, events, err = OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Error("expected to replay", expected, "but received", events)
+	}
+}
+
+func TestFileLogCorruptTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := Event{Id: 0, AggregateID: "some game", EventData: "12-20", EventType: 1}
+	second := Event{Id: 1, AggregateID: "some game", EventData: "52-36", EventType: 1}
+	l.Append(first)
+	l.Append(second)
+	l.Close()
+
+	segments, _ := l.segments()
+	path := filepath.Join(dir, segments[len(segments)-1])
+	info, _ := os.Stat(path)
+	// simulate a crash in the middle of writing the second record
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l, events, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal("expected a truncated tail to be cut off but failed with", err)
+	}
+	if !reflect.DeepEqual(events, []Event{first}) {
+		t.Error("expected to replay only", first, "but received", events)
+	}
+
+	// the log should be writable again right after the cut
+	if err := l.Append(second); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, events, _ = OpenFileLog(dir); !reflect.DeepEqual(events, []Event{first, second}) {
+		t.Error("expected to replay", []Event{first, second}, "but received", events)
+	}
+}
+
+func TestFileEventStoreWritesThrough(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddEvent(Event{AggregateID: "some game", EventData: "12-20", EventType: 1})
+	s.AddEvent(Event{AggregateID: "some game", EventData: "52-36", EventType: 1})
+	s.backend.(*FileBackend).Close()
+
+	restarted, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restarted.Events(), s.Events()) {
+		t.Error("expected", s.Events(), "after restart but received", restarted.Events())
+	}
+	if ev, _ := restarted.AddEvent(Event{AggregateID: "some game"}); ev.Id != 2 {
+		t.Error("expected ids to continue from the replayed log but got", ev.Id)
+	}
+}
+
+func TestReadFileLogChangesNothing(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.segmentSize = 200
+	var expected []Event
+	for i := 0; i < 5; i++ {
+		ev := Event{Id: i, AggregateID: "some game", EventData: "12-20", EventType: 1}
+		l.Append(ev)
+		expected = append(expected, ev)
+	}
+	// half an append, the leftovers of a rewrite and snapshots to be compacted
+	l.segment.Write([]byte{0, 0, 1})
+	l.Close()
+	ioutil.WriteFile(filepath.Join(dir, "00000000000000000000"+segmentExt+rewriteExt), []byte("new"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, snapshotsFile), []byte(`{"AggregateID":"some game","Moves":1}`+"\n"+`{"AggregateID":"some game","Moves":0,"Drop":true}`+"\n"), 0644)
+	contents := func() map[string]string {
+		files := map[string]string{}
+		infos, _ := ioutil.ReadDir(dir)
+		for _, info := range infos {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+			files[info.Name()] = string(data)
+		}
+		return files
+	}
+	before := contents()
+
+	s, err := NewReadOnlyFileEventStore(dir, nil)
+	if _, ok := err.(*CorruptTailError); !ok {
+		t.Error("expected the half written record to be reported but got", err)
+	}
+	if !reflect.DeepEqual(s.Events(), expected) {
+		t.Error("expected the events before the tail", expected, "but got", s.Events())
+	}
+	s.SaveSnapshot(Snapshot{AggregateID: "other game", Moves: 1})
+	if _, err := s.Persist(context.Background(), Event{AggregateID: "some game", EventType: 1}); err != ErrReadOnly {
+		t.Error("expected the store to be read-only but got", err)
+	}
+	if after := contents(); !reflect.DeepEqual(after, before) {
+		t.Error("expected the log's dir to be left as it was", before, "but got", after)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, rewriteCommitted), nil, 0644)
+	if _, err := ReadFileLog(dir); err == nil {
+		t.Error("expected a log in the middle of a rewrite not to be read")
+	}
+}
@@ -1,13 +1,252 @@
 package store
 
-type EventListener struct {
-	NotifFn func(*EventStore, Event)
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens to an event when a listener's queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, holding up every other listener meanwhile
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops the event and records it as a dead letter
+	OverflowDrop
+	// OverflowDisconnect records the event as a dead letter and unregisters the listener
+	OverflowDisconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDrop:
+		return "drop"
+	case OverflowDisconnect:
+		return "disconnect"
+	}
+	return "block"
 }
 
-func (h *EventListener) notify(store *EventStore, e Event) {
-	h.NotifFn(store, e)
+const DefaultQueueSize = 256
+
+// EventListener is notified of events from a queue of its own so a slow or
+// panicking listener doesn't hold up the others (unless its Overflow is
+// OverflowBlock). The fields are read when the listener registers so
+// changing them afterwards has no effect.
+type EventListener struct {
+	NotifFn func(*EventStore, Event)
+	// Filter selects the events the listener is notified of
+	Filter Filter
+	// Name identifies the listener in dead letters
+	Name string
+	// QueueSize is how many events may wait for each of the listener's workers, DefaultQueueSize if 0
+	QueueSize int
+	Overflow  OverflowPolicy
+	// Workers is how many goroutines handle the listener's events, 1 if 0.
+	// The events of an aggregate always go to the same worker so they're
+	// handled in order, while the events of other aggregates are handled in
+	// parallel. NotifFn must be safe to call concurrently if it's more than 1.
+	Workers int
+	// OnDisconnect is called once the store unregisters the listener on overflow
+	OnDisconnect func()
 }
 
 func NewEventHandler(cb func(*EventStore, Event)) *EventListener {
 	return &EventListener{NotifFn: cb}
 }
+
+// NewFilteredEventHandler returns a listener that's only notified of the events matching f
+func NewFilteredEventHandler(f Filter, cb func(*EventStore, Event)) *EventListener {
+	return &EventListener{NotifFn: cb, Filter: f}
+}
+
+func (h *EventListener) name() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return fmt.Sprintf("listener %p", h)
+}
+
+// registration is a registered listener with the queues of its workers and the goroutines draining them
+type registration struct {
+	listener *EventListener
+	// after is the Id of the last event appended before the listener registered
+	after   int
+	types   map[int]bool
+	queues  []chan Event
+	quit    chan struct{}
+	removed int32
+	wg      sync.WaitGroup
+}
+
+func newRegistration(l *EventListener, after int) *registration {
+	size := l.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	workers := l.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	r := &registration{
+		listener: l,
+		after:    after,
+		queues:   make([]chan Event, workers),
+		quit:     make(chan struct{}),
+	}
+	for i := range r.queues {
+		r.queues[i] = make(chan Event, size)
+	}
+	if len(l.Filter.EventTypes) > 0 {
+		r.types = make(map[int]bool, len(l.Filter.EventTypes))
+		for _, t := range l.Filter.EventTypes {
+			r.types[t] = true
+		}
+	}
+	return r
+}
+
+func (r *registration) start(store *EventStore) {
+	for _, queue := range r.queues {
+		r.wg.Add(1)
+		go r.work(store, queue)
+	}
+}
+
+func (r *registration) work(store *EventStore, queue chan Event) {
+	defer r.wg.Done()
+	for {
+		select {
+		case e := <-queue:
+			if atomic.LoadInt32(&r.removed) != 0 {
+				store.track(-1)
+				r.abandon(store)
+				return
+			}
+			r.notify(store, e)
+			store.track(-1)
+		case <-r.quit:
+			r.abandon(store)
+			return
+		}
+	}
+}
+
+// queue returns the queue of the worker handling the events of e's aggregate
+func (r *registration) queue(e Event) chan Event {
+	if len(r.queues) == 1 {
+		return r.queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(e.AggregateID))
+	return r.queues[h.Sum32()%uint32(len(r.queues))]
+}
+
+func (r *registration) notify(store *EventStore, e Event) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("store: %s panicked handling %v: %v", r.listener.name(), e, p)
+			store.addDeadLetter(r.listener, e, fmt.Sprintf("panic: %v", p))
+		}
+	}()
+	r.listener.NotifFn(store, e)
+}
+
+// matchType checks the event type only, routing has taken care of the aggregate
+func (r *registration) matchType(e Event) bool {
+	return r.types == nil || r.types[e.EventType]
+}
+
+// push hands the event to the listener's queue, following its overflow policy if it's full.
+// The event counts as in flight until the listener handled it.
+func (r *registration) push(store *EventStore, e Event) {
+	store.track(1)
+	if !r.send(store, e) {
+		store.track(-1)
+		return
+	}
+	// the listener may have been removed after its goroutine let go of the queue
+	if atomic.LoadInt32(&r.removed) != 0 {
+		r.abandon(store)
+	}
+}
+
+// send tries to queue the event and tells if it did
+func (r *registration) send(store *EventStore, e Event) bool {
+	queue := r.queue(e)
+	if r.listener.Overflow == OverflowBlock {
+		select {
+		case queue <- e:
+			return true
+		case <-r.quit:
+			return false
+		}
+	}
+
+	select {
+	case queue <- e:
+		return true
+	default:
+	}
+	if r.listener.Overflow == OverflowDrop {
+		store.addDeadLetter(r.listener, e, "queue full, event dropped")
+		return false
+	}
+	store.addDeadLetter(r.listener, e, "queue full, listener disconnected")
+	store.Unregister(r.listener)
+	if r.listener.OnDisconnect != nil {
+		go r.listener.OnDisconnect()
+	}
+	return false
+}
+
+// abandon empties the queues of a removed listener, their events are no longer in flight
+func (r *registration) abandon(store *EventStore) {
+	for _, queue := range r.queues {
+		for empty := false; !empty; {
+			select {
+			case <-queue:
+				store.track(-1)
+			default:
+				empty = true
+			}
+		}
+	}
+}
+
+// stop ends the registration, it's safe to call more than once
+func (r *registration) stop() {
+	if atomic.CompareAndSwapInt32(&r.removed, 0, 1) {
+		close(r.quit)
+	}
+}
+
+// routes indexes registrations by the aggregates they're interested in so an
+// event is only handed to the listeners that may want it
+type routes struct {
+	registrations []*registration
+	any           []*registration
+	byAggregate   map[string][]*registration
+}
+
+func newRoutes(registrations []*registration) *routes {
+	r := &routes{registrations: registrations, byAggregate: map[string][]*registration{}}
+	for _, reg := range registrations {
+		ids := reg.listener.Filter.AggregateIDs
+		if len(ids) == 0 {
+			r.any = append(r.any, reg)
+			continue
+		}
+		seen := map[string]bool{}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				r.byAggregate[id] = append(r.byAggregate[id], reg)
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowListenerDoesNotBlockOthers(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDrop, OverflowDisconnect} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s := NewEventStore()
+			s.Run()
+
+			release := make(chan struct{})
+			defer close(release)
+			disconnected := make(chan struct{})
+			s.Register(&EventListener{
+				Name:      "slow",
+				QueueSize: 1,
+				Overflow:  policy,
+				NotifFn: func(*EventStore, Event) {
+					<-release
+				},
+				OnDisconnect: func() {
+					close(disconnected)
+				},
+			})
+			var fast int32
+			s.Register(NewEventHandler(func(*EventStore, Event) {
+				atomic.AddInt32(&fast, 1)
+			}))
+
+			const events = 10
+			for i := 0; i < events; i++ {
+				s.Persist(context.Background(), Event{AggregateID: "some game"})
+			}
+			eventually(t, func() bool {
+				return atomic.LoadInt32(&fast) == events
+			})
+
+			deadLetters := s.DeadLetters()
+			if len(deadLetters) == 0 || deadLetters[0].Listener != "slow" {
+				t.Fatal("expected the events the slow listener couldn't take to be dead letters but got", deadLetters)
+			}
+			if policy == OverflowDisconnect {
+				<-disconnected
+				if len(deadLetters) != 1 || s.Listeners() != 1 {
+					t.Error("expected the slow listener to be disconnected on the first overflow but got", deadLetters)
+				}
+			}
+		})
+	}
+}
+
+func TestPanickingListener(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	var handled int32
+	s.Register(&EventListener{
+		Name: "panicky",
+		NotifFn: func(_ *EventStore, e Event) {
+			if e.EventData == "boom" {
+				panic("boom")
+			}
+			atomic.AddInt32(&handled, 1)
+		},
+	})
+
+	s.Persist(context.Background(), Event{AggregateID: "some game", EventData: "boom"})
+	s.Persist(context.Background(), Event{AggregateID: "some game", EventData: "fine"})
+	eventually(t, func() bool {
+		return atomic.LoadInt32(&handled) == 1
+	})
+
+	deadLetters := s.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Event.EventData != "boom" || !strings.Contains(deadLetters[0].Reason, "panic") {
+		t.Error("expected the panic to be recorded as a dead letter but got", deadLetters)
+	}
+}
+
+func TestWorkersKeepAggregateOrder(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	const games, moves = 50, 20
+	var (
+		mu      sync.Mutex
+		last    = map[string]int{}
+		handled int32
+	)
+	s.Register(&EventListener{
+		Workers: 4,
+		NotifFn: func(_ *EventStore, e Event) {
+			mu.Lock()
+			if e.Version != last[e.AggregateID]+1 {
+				t.Errorf("expected version %d of %s but got %d", last[e.AggregateID]+1, e.AggregateID, e.Version)
+			}
+			last[e.AggregateID] = e.Version
+			mu.Unlock()
+			atomic.AddInt32(&handled, 1)
+		},
+	})
+	for m := 0; m < moves; m++ {
+		for g := 0; g < games; g++ {
+			s.Persist(context.Background(), Event{AggregateID: fmt.Sprintf("game %d", g)})
+		}
+	}
+	eventually(t, func() bool {
+		return atomic.LoadInt32(&handled) == games*moves
+	})
+}
+
+func TestWorkersDoNotWaitOnOtherGames(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	release := make(chan struct{})
+	defer close(release)
+	handled := make(chan string, 1)
+	l := &EventListener{
+		Workers: 2,
+		NotifFn: func(_ *EventStore, e Event) {
+			if e.AggregateID == "busy" {
+				<-release
+				return
+			}
+			handled <- e.AggregateID
+		},
+	}
+	s.Register(l)
+
+	// find a game the other worker handles
+	r := newRegistration(l, -1)
+	other := Event{AggregateID: "game 0"}
+	for i := 1; r.queue(other) == r.queue(Event{AggregateID: "busy"}); i++ {
+		other.AggregateID = fmt.Sprintf("game %d", i)
+	}
+
+	s.Persist(context.Background(), Event{AggregateID: "busy"})
+	s.Persist(context.Background(), other)
+	select {
+	case id := <-handled:
+		if id != other.AggregateID {
+			t.Error("expected", other.AggregateID, "to be handled but got", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the other game to be handled while the busy one is")
+	}
+}
+
+// BenchmarkWorkers handles the moves of many games at once, every event
+// takes the listener a while as the game's handlers do when they rebuild a game
+func BenchmarkWorkers(b *testing.B) {
+	const moves = 10
+	for _, games := range []int{100, 500} {
+		for _, workers := range []int{1, 8, 32} {
+			b.Run(fmt.Sprintf("games=%d/workers=%d", games, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					s := NewEventStore()
+					s.Run()
+					var handled sync.WaitGroup
+					handled.Add(games * moves)
+					s.Register(&EventListener{
+						Workers: workers,
+						NotifFn: func(*EventStore, Event) {
+							time.Sleep(100 * time.Microsecond)
+							handled.Done()
+						},
+					})
+					var wg sync.WaitGroup
+					for g := 0; g < games; g++ {
+						wg.Add(1)
+						go func(g int) {
+							defer wg.Done()
+							for m := 0; m < moves; m++ {
+								s.Persist(context.Background(), Event{AggregateID: fmt.Sprintf("game %d", g)})
+							}
+						}(g)
+					}
+					wg.Wait()
+					handled.Wait()
+				}
+			})
+		}
+	}
+}
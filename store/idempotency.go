@@ -0,0 +1,62 @@
+package store
+
+import "time"
+
+// DefaultIdempotencyWindow is how long a store remembers idempotency keys
+const DefaultIdempotencyWindow = 10 * time.Minute
+
+type idempotencyKey struct {
+	aggregateID string
+	key         string
+}
+
+// idempotency remembers the events appended with an idempotency key
+// for as long as the window since their timestamp
+type idempotency struct {
+	window time.Duration
+	events map[idempotencyKey]Event
+	// order holds the keys in append order so the expired ones can be let go from the front
+	order []idempotencyKey
+}
+
+func newIdempotency(window time.Duration) *idempotency {
+	return &idempotency{window: window, events: map[idempotencyKey]Event{}}
+}
+
+func (i *idempotency) lookup(ev Event, now time.Time) (Event, bool) {
+	if ev.Metadata.IdempotencyKey == "" {
+		return Event{}, false
+	}
+	i.expire(now)
+	original, ok := i.events[idempotencyKey{ev.AggregateID, ev.Metadata.IdempotencyKey}]
+	return original, ok
+}
+
+func (i *idempotency) remember(ev Event) {
+	if ev.Metadata.IdempotencyKey == "" {
+		return
+	}
+	k := idempotencyKey{ev.AggregateID, ev.Metadata.IdempotencyKey}
+	i.events[k] = ev
+	i.order = append(i.order, k)
+}
+
+func (i *idempotency) expire(now time.Time) {
+	n := 0
+	for ; n < len(i.order); n++ {
+		ev, ok := i.events[i.order[n]]
+		if ok && now.Sub(ev.Metadata.Timestamp) < i.window {
+			break
+		}
+		delete(i.events, i.order[n])
+	}
+	i.order = i.order[n:]
+}
+
+// SetIdempotencyWindow sets how long idempotency keys are remembered for,
+// it must be called before the store is used
+func (store *EventStore) SetIdempotencyWindow(window time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.idempotency.window = window
+}
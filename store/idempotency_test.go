@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func keyed(aggregateID, key string) Event {
+	return Event{AggregateID: aggregateID, EventType: 1, Metadata: Metadata{IdempotencyKey: key}}
+}
+
+func TestPersistDeduplicatesKeys(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewEventStore()
+	s.clock = func() time.Time { return now }
+	s.SetIdempotencyWindow(time.Minute)
+	ctx := context.Background()
+
+	first, err := s.Persist(ctx, keyed("some game", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	again, err := s.Persist(ctx, keyed("some game", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != first.Id || s.LastID() != first.Id {
+		t.Error("expected the retry to return", first, "without appending but got", again, "and", s.Events())
+	}
+	// the version was taken by the original, the retry mustn't conflict with it
+	if again, err := s.PersistExpected(ctx, keyed("some game", "a"), 0); err != nil || again.Id != first.Id {
+		t.Error("expected the retry to return", first, "but got", again, err)
+	}
+
+	// keys are per aggregate
+	if other, _ := s.Persist(ctx, keyed("other game", "a")); other.Id == first.Id {
+		t.Error("expected a new event for another game but got", other)
+	}
+
+	now = now.Add(time.Minute)
+	if late, _ := s.Persist(ctx, keyed("some game", "a")); late.Id == first.Id {
+		t.Error("expected the key to be forgotten after the window but got", late)
+	}
+}
+
+func TestIdempotencyKeysSurviveRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Persist(context.Background(), keyed("some game", "a"))
+	s.backend.(*FileBackend).Close()
+
+	restarted, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := restarted.Persist(context.Background(), keyed("some game", "a")); again.Id != first.Id || restarted.LastID() != first.Id {
+		t.Error("expected the retry to return", first, "but got", again)
+	}
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Process is a running instance of a workflow that spans several events.
+// Its state must only change in Handle, the events it's handed are all that's
+// left of it to rebuild it from when the store is restarted.
+type Process interface {
+	// Handle moves the process on with an event correlated with it
+	// and collects what it decides to do about it in cmds
+	Handle(e Event, cmds *Commands)
+	// Done tells if the process is over, it's dropped once it is
+	Done() bool
+}
+
+// ProcessManager runs a Process for every group of correlated events
+type ProcessManager struct {
+	Name   string
+	Filter Filter
+	// Correlate returns the key of the process an event belongs to, "" if none
+	Correlate func(e Event) string
+	// Start returns a new process if e starts one and nil otherwise,
+	// it's only asked about events no running process is correlated with
+	Start func(e Event) Process
+}
+
+// Commands collects what a process decides to do while it handles an event.
+// Events without a CausationID are caused by the event being handled.
+type Commands struct {
+	events    []Event
+	schedules []Schedule
+	cancels   []string
+}
+
+// Persist appends an event
+func (c *Commands) Persist(e Event) {
+	c.events = append(c.events, e)
+}
+
+// Schedule persists an event once at comes, see EventStore.Schedule
+func (c *Commands) Schedule(key string, at time.Time, e Event) {
+	c.schedules = append(c.schedules, Schedule{Key: key, At: at, Event: e})
+}
+
+// Cancel drops a schedule, see EventStore.Cancel
+func (c *Commands) Cancel(key string) {
+	c.cancels = append(c.cancels, key)
+}
+
+func (c *Commands) Events() []Event {
+	return c.events
+}
+
+func (c *Commands) Schedules() []Schedule {
+	return c.schedules
+}
+
+func (c *Commands) Cancels() []string {
+	return c.cancels
+}
+
+// processRunner feeds the events of the store to the processes of a manager
+type processRunner struct {
+	store     *EventStore
+	manager   *ProcessManager
+	processes map[string]Process
+	// replayUntil is the last event that was appended before the runner started,
+	// the commands of events up to it were carried out before
+	replayUntil int
+}
+
+// StartProcessManager rebuilds the processes of pm from the events in the
+// store and keeps running them from then on
+func (store *EventStore) StartProcessManager(pm *ProcessManager) error {
+	r := &processRunner{
+		store:       store,
+		manager:     pm,
+		processes:   map[string]Process{},
+		replayUntil: store.LastID(),
+	}
+	return store.Subscribe(&EventListener{
+		Name:    "process manager " + pm.Name,
+		Filter:  pm.Filter,
+		NotifFn: r.handle,
+	}, -1)
+}
+
+func (r *processRunner) handle(_ *EventStore, e Event) {
+	key := r.manager.Correlate(e)
+	if key == "" {
+		return
+	}
+	p, ok := r.processes[key]
+	if !ok {
+		if p = r.manager.Start(e); p == nil {
+			return
+		}
+		r.processes[key] = p
+	}
+	var cmds Commands
+	p.Handle(e, &cmds)
+	if p.Done() {
+		delete(r.processes, key)
+	}
+	if e.Id <= r.replayUntil {
+		return
+	}
+	r.execute(e, &cmds)
+}
+
+func (r *processRunner) execute(cause Event, cmds *Commands) {
+	ctx := context.Background()
+	caused := func(e Event) Event {
+		if e.Metadata.CausationID == nil {
+			actor := e.Metadata.Actor
+			e.Metadata = CausedBy(cause)
+			if actor != "" {
+				e.Metadata.Actor = actor
+			}
+		}
+		return e
+	}
+	for _, key := range cmds.cancels {
+		if _, err := r.store.Cancel(ctx, key); err != nil {
+			log.Printf("process manager %s: failed cancelling %s: %v", r.manager.Name, key, err)
+		}
+	}
+	for _, s := range cmds.schedules {
+		if err := r.store.Schedule(ctx, s.Key, s.At, caused(s.Event)); err != nil {
+			log.Printf("process manager %s: failed scheduling %s: %v", r.manager.Name, s.Key, err)
+		}
+	}
+	for _, e := range cmds.events {
+		if _, err := r.store.Persist(ctx, caused(e)); err != nil {
+			log.Printf("process manager %s: failed persisting %v: %v", r.manager.Name, e, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// tally counts the events of type 1 of an aggregate, answers each of them with
+// an event of type 2 and reports the count with an event of type 4 on type 3
+type tally struct {
+	count int
+	done  bool
+}
+
+func (p *tally) Handle(e Event, cmds *Commands) {
+	switch e.EventType {
+	case 1:
+		p.count++
+		cmds.Persist(Event{AggregateID: e.AggregateID, EventType: 2})
+	case 3:
+		p.done = true
+		cmds.Persist(Event{AggregateID: e.AggregateID, EventType: 4, EventData: strconv.Itoa(p.count)})
+	}
+}
+
+func (p *tally) Done() bool {
+	return p.done
+}
+
+func tallies() *ProcessManager {
+	return &ProcessManager{
+		Name:      "tally",
+		Filter:    Filter{EventTypes: []int{1, 3}},
+		Correlate: func(e Event) string { return e.AggregateID },
+		Start: func(e Event) Process {
+			if e.EventType != 1 {
+				return nil
+			}
+			return &tally{}
+		},
+	}
+}
+
+func ofType(events []Event, eventType int) []Event {
+	var matching []Event
+	for _, e := range events {
+		if e.EventType == eventType {
+			matching = append(matching, e)
+		}
+	}
+	return matching
+}
+
+func TestProcessManagerRebuildsFromLog(t *testing.T) {
+	b := NewMemoryBackend()
+	s := NewBackendEventStore(b, nil)
+	s.Run()
+	if err := s.StartProcessManager(tallies()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s.Persist(ctx, Event{AggregateID: "some game", EventType: 1})
+	s.Persist(ctx, Event{AggregateID: "some game", EventType: 1})
+	// a process only starts on type 1
+	s.Persist(ctx, Event{AggregateID: "other game", EventType: 3})
+	eventually(t, func() bool {
+		return len(ofType(s.Events(), 2)) == 2
+	})
+	answers := ofType(s.Events(), 2)
+	if cause := answers[0].Metadata.CausationID; cause == nil || *cause != 0 {
+		t.Error("expected the answer to be caused by the event it answers but got", answers[0])
+	}
+
+	// the restarted store's process picks up the count without answering again
+	restarted := NewBackendEventStore(b, nil)
+	restarted.Run()
+	if err := restarted.StartProcessManager(tallies()); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Persist(ctx, Event{AggregateID: "some game", EventType: 3})
+	eventually(t, func() bool {
+		return len(ofType(restarted.Events(), 4)) == 1
+	})
+	if report := ofType(restarted.Events(), 4)[0]; report.EventData != "2" || report.AggregateID != "some game" {
+		t.Error("expected the process to report 2 events but got", report)
+	}
+	if answers := ofType(restarted.Events(), 2); len(answers) != 2 {
+		t.Error("expected replaying not to answer again but got", answers)
+	}
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types of the store's own events, they're negative so they never
+// clash with the types of an application
+const (
+	EventScheduled         = -1
+	EventScheduleCancelled = -2
+)
+
+// SchedulerAggregateID is the stream schedules are kept in
+const SchedulerAggregateID = "$scheduler"
+
+// IsSystemAggregate tells if an aggregate holds the store's own events rather than an application's
+func IsSystemAggregate(aggregateID string) bool {
+	return strings.HasPrefix(aggregateID, "$")
+}
+
+// Schedule is an event to be persisted once At comes
+type Schedule struct {
+	Key   string
+	At    time.Time
+	Event Event
+}
+
+// scheduleCancelled is the data of EventScheduleCancelled
+type scheduleCancelled struct {
+	Key string
+}
+
+// registerSystemTypes adds the types of the store's own events to r
+func registerSystemTypes(r *TypeRegistry) {
+	r.Register(EventType{Type: EventScheduled, Name: "store.Scheduled", Version: 1,
+		New: func() interface{} { return &Schedule{} }})
+	r.Register(EventType{Type: EventScheduleCancelled, Name: "store.ScheduleCancelled", Version: 1,
+		New: func() interface{} { return &scheduleCancelled{} }})
+	r.Register(EventType{Type: EventArchived, Name: "store.Archived", Version: 1,
+		New: func() interface{} { return &Archive{} }})
+}
+
+// pendingSchedule is a schedule that didn't fire yet, id and correlation are those of its EventScheduled
+type pendingSchedule struct {
+	Schedule
+	id          int
+	correlation string
+}
+
+// scheduler holds the schedules that didn't fire and weren't cancelled
+type scheduler struct {
+	// mu is held while appending schedule events so a schedule can't fire and
+	// be cancelled at the same time
+	mu      sync.Mutex
+	pending map[string]pendingSchedule
+	// byID maps the Id of every pending EventScheduled to its key
+	byID map[int]string
+	wake chan struct{}
+	// types brings the events of schedules up to date, it's nil if the store has none
+	types *TypeRegistry
+}
+
+func newScheduler(types *TypeRegistry) *scheduler {
+	return &scheduler{
+		pending: map[string]pendingSchedule{},
+		byID:    map[int]string{},
+		wake:    make(chan struct{}, 1),
+		types:   types,
+	}
+}
+
+// replay takes the state of the schedules from an event, either
+// appended by the store or read back from its log
+func (s *scheduler) replay(ev Event) {
+	switch {
+	case ev.EventType == EventScheduled && ev.AggregateID == SchedulerAggregateID:
+		var sch Schedule
+		if err := json.Unmarshal([]byte(ev.EventData), &sch); err != nil {
+			log.Println("store: skipping unreadable schedule", ev, err)
+			return
+		}
+		// the event was stamped when it was scheduled, its type may have moved on since
+		if s.types != nil {
+			e, err := s.types.Upcast(sch.Event)
+			if err != nil {
+				log.Println("store: skipping schedule of an unknown event", ev, err)
+				return
+			}
+			sch.Event = e
+		}
+		s.remove(sch.Key)
+		s.pending[sch.Key] = pendingSchedule{Schedule: sch, id: ev.Id, correlation: ev.Metadata.CorrelationID}
+		s.byID[ev.Id] = sch.Key
+	case ev.EventType == EventScheduleCancelled && ev.AggregateID == SchedulerAggregateID:
+		var c scheduleCancelled
+		if err := json.Unmarshal([]byte(ev.EventData), &c); err == nil {
+			s.remove(c.Key)
+		}
+	case ev.Metadata.CausationID != nil:
+		// a fired schedule is the cause of the event it persisted
+		if key, ok := s.byID[*ev.Metadata.CausationID]; ok {
+			s.remove(key)
+		}
+	}
+}
+
+func (s *scheduler) remove(key string) {
+	if p, ok := s.pending[key]; ok {
+		delete(s.byID, p.id)
+		delete(s.pending, key)
+	}
+}
+
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Schedule persists e once at comes, even if the store is restarted in between.
+// Scheduling a key that's already pending replaces its schedule.
+// The persisted event is caused by the schedule and shares its correlation.
+func (store *EventStore) Schedule(ctx context.Context, key string, at time.Time, e Event) error {
+	if store.types != nil {
+		var err error
+		if e, err = store.types.stamp(e); err != nil {
+			return err
+		}
+	}
+	store.scheduler.mu.Lock()
+	defer store.scheduler.mu.Unlock()
+	_, err := store.Persist(ctx, Event{
+		AggregateID: SchedulerAggregateID,
+		EventType:   EventScheduled,
+		EventData:   EncodePayload(Schedule{Key: key, At: at.UTC(), Event: e}),
+		Metadata: Metadata{
+			Actor:         e.Metadata.Actor,
+			CorrelationID: e.Metadata.CorrelationID,
+			CausationID:   e.Metadata.CausationID,
+		},
+	})
+	store.scheduler.poke()
+	return err
+}
+
+// Cancel drops the schedule of key, it returns false if there's none because
+// it was never scheduled, it fired already or it was cancelled before
+func (store *EventStore) Cancel(ctx context.Context, key string) (bool, error) {
+	store.scheduler.mu.Lock()
+	defer store.scheduler.mu.Unlock()
+	store.mu.RLock()
+	_, ok := store.scheduler.pending[key]
+	store.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	_, err := store.Persist(ctx, Event{
+		AggregateID: SchedulerAggregateID,
+		EventType:   EventScheduleCancelled,
+		EventData:   EncodePayload(scheduleCancelled{Key: key}),
+	})
+	return err == nil, err
+}
+
+// Scheduled returns the pending schedules, the soonest first
+func (store *EventStore) Scheduled() []Schedule {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	schedules := make([]Schedule, 0, len(store.scheduler.pending))
+	for _, p := range store.scheduler.pending {
+		schedules = append(schedules, p.Schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].At.Before(schedules[j].At)
+	})
+	return schedules
+}
+
+// runScheduler fires schedules as they come due, schedules that came due
+// while the store was down fire as soon as it's started
+func (store *EventStore) runScheduler() {
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-store.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-store.scheduler.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		timer.Reset(store.fireDue())
+	}
+}
+
+// maxSchedulerSleep bounds how long the scheduler waits, so a clock that
+// jumps doesn't hold a schedule back for long
+const maxSchedulerSleep = time.Minute
+
+// fireDue persists the events of every schedule that came due and returns how long until the next one does
+func (store *EventStore) fireDue() time.Duration {
+	// a follower's leader fires the schedules, the events come with the rest
+	if store.readOnly {
+		return maxSchedulerSleep
+	}
+	store.scheduler.mu.Lock()
+	defer store.scheduler.mu.Unlock()
+
+	store.mu.RLock()
+	now := store.clock()
+	var due []pendingSchedule
+	next := maxSchedulerSleep
+	for _, p := range store.scheduler.pending {
+		if wait := p.At.Sub(now); wait > 0 {
+			if wait < next {
+				next = wait
+			}
+			continue
+		}
+		due = append(due, p)
+	}
+	store.mu.RUnlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].id < due[j].id
+	})
+	for _, p := range due {
+		e := p.Event
+		id := p.id
+		e.Metadata.CausationID = &id
+		e.Metadata.CorrelationID = p.correlation
+		_, err := store.Persist(context.Background(), e)
+		if err == ErrArchived {
+			// the aggregate won't take the event ever, so the schedule is dropped rather than retried
+			log.Println("store: dropping schedule", p.Key, "of an archived aggregate")
+			_, err = store.Persist(context.Background(), Event{
+				AggregateID: SchedulerAggregateID,
+				EventType:   EventScheduleCancelled,
+				EventData:   EncodePayload(scheduleCancelled{Key: p.Key}),
+			})
+		}
+		if err != nil {
+			log.Println("store: failed firing schedule", p.Key, err)
+			if next > time.Second {
+				next = time.Second
+			}
+		}
+	}
+	return next
+}
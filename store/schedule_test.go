@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func fired(s *EventStore, aggregateID string) []Event {
+	return s.ReadStream(aggregateID, 0)
+}
+
+func TestScheduleFiresWhenDue(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewEventStore()
+	s.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	timeout := Event{AggregateID: "some game", EventType: 1, Metadata: Metadata{CorrelationID: "move"}}
+	if err := s.Schedule(ctx, "timeout", now.Add(time.Minute), timeout); err != nil {
+		t.Fatal(err)
+	}
+	scheduleID := s.LastID()
+	if next := s.fireDue(); next != time.Minute || len(fired(s, "some game")) != 0 {
+		t.Fatal("expected nothing to fire for another minute but got", next, fired(s, "some game"))
+	}
+
+	now = now.Add(time.Minute)
+	s.fireDue()
+	events := fired(s, "some game")
+	if len(events) != 1 || *events[0].Metadata.CausationID != scheduleID || events[0].Metadata.CorrelationID != "move" {
+		t.Fatal("expected the timeout to be persisted, caused by its schedule, but got", events)
+	}
+	if len(s.Scheduled()) != 0 {
+		t.Error("expected no schedules left but got", s.Scheduled())
+	}
+	if s.fireDue(); len(fired(s, "some game")) != 1 {
+		t.Error("expected the schedule to fire once but got", fired(s, "some game"))
+	}
+}
+
+func TestScheduleCancelAndReplace(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewEventStore()
+	s.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	s.Schedule(ctx, "timeout", now.Add(time.Minute), Event{AggregateID: "some game", EventType: 1})
+	s.Schedule(ctx, "timeout", now.Add(2*time.Minute), Event{AggregateID: "some game", EventType: 2})
+	if scheduled := s.Scheduled(); len(scheduled) != 1 || scheduled[0].Event.EventType != 2 {
+		t.Fatal("expected the second schedule to replace the first but got", scheduled)
+	}
+	if ok, err := s.Cancel(ctx, "timeout"); !ok || err != nil {
+		t.Fatal("expected to cancel the timeout but got", ok, err)
+	}
+	if ok, _ := s.Cancel(ctx, "timeout"); ok {
+		t.Error("expected cancelling twice to find nothing")
+	}
+	now = now.Add(time.Hour)
+	if s.fireDue(); len(fired(s, "some game")) != 0 {
+		t.Error("expected a cancelled schedule not to fire but got", fired(s, "some game"))
+	}
+}
+
+func TestScheduleSurvivesRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	open := func() *EventStore {
+		s, err := NewFileEventStore(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.clock = func() time.Time { return now }
+		return s
+	}
+	ctx := context.Background()
+
+	s := open()
+	s.Schedule(ctx, "kept", now.Add(time.Minute), Event{AggregateID: "some game", EventType: 1})
+	s.Schedule(ctx, "cancelled", now.Add(time.Minute), Event{AggregateID: "other game", EventType: 1})
+	s.Cancel(ctx, "cancelled")
+	s.backend.(*FileBackend).Close()
+
+	s = open()
+	if scheduled := s.Scheduled(); len(scheduled) != 1 || scheduled[0].Key != "kept" {
+		t.Fatal("expected only the kept schedule after a restart but got", scheduled)
+	}
+	now = now.Add(time.Minute)
+	s.fireDue()
+	s.backend.(*FileBackend).Close()
+
+	s = open()
+	if scheduled := s.Scheduled(); len(scheduled) != 0 {
+		t.Error("expected the fired schedule to be gone after a restart but got", scheduled)
+	}
+}
+
+func TestScheduleOfAnOldVersionReplays(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a greeting scheduled back when it was version 1 and type 1
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greet := Event{AggregateID: "some game", EventType: 1, EventData: "hello bob", TypeName: "Greeted", SchemaVersion: 1}
+	l.Append(Event{Id: 0, AggregateID: SchedulerAggregateID, Version: 1, EventType: EventScheduled,
+		TypeName: "store.Scheduled", SchemaVersion: 1,
+		EventData: EncodePayload(Schedule{Key: "greet", At: now.Add(time.Minute), Event: greet})})
+	l.Close()
+
+	types := greetingTypes(7)
+	s, err := NewFileEventStore(dir, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.rawBackend().(*FileBackend).Close()
+	s.clock = func() time.Time { return now }
+	scheduled := s.Scheduled()
+	if len(scheduled) != 1 || scheduled[0].Event.EventType != 7 || scheduled[0].Event.SchemaVersion != 2 {
+		t.Fatal("expected the scheduled greeting to be brought up to date but got", scheduled)
+	}
+
+	now = now.Add(time.Minute)
+	s.fireDue()
+	events := fired(s, "some game")
+	if len(events) != 1 || events[0].EventType != 7 || events[0].SchemaVersion != 2 {
+		t.Fatal("expected the greeting to fire as the current version but got", events)
+	}
+	if payload, err := types.Decode(events[0]); err != nil || payload.(*greeting).Name != "bob" {
+		t.Error("expected to greet bob but got", payload, err)
+	}
+}
+
+func TestRunFiresSchedules(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+	s.Schedule(context.Background(), "soon", time.Now().Add(20*time.Millisecond), Event{AggregateID: "some game", EventType: 1})
+	eventually(t, func() bool {
+		return len(fired(s, "some game")) == 1
+	})
+}
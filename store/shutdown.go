@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is how often Shutdown checks whether the listeners are done
+const drainPollInterval = 5 * time.Millisecond
+
+// track adds n to the events in flight
+func (store *EventStore) track(n int64) {
+	atomic.AddInt64(&store.inFlight, n)
+}
+
+// Shutdown stops a running store without losing the events appended so far.
+// From the start it only takes events caused by one it already has, new ones
+// fail with ErrClosed. It stops firing schedules and waits for the listeners
+// to handle every event in flight, the events they append meanwhile included.
+// Then every append fails, every listener is unregistered and the backend is
+// closed if it can be.
+// If ctx is done before the listeners are, the store is stopped all the same
+// and ctx's error returned, the events they didn't get to are still in the log.
+func (store *EventStore) Shutdown(ctx context.Context) error {
+	store.mu.Lock()
+	store.closing = true
+	store.mu.Unlock()
+	store.doneOnce.Do(func() { close(store.done) })
+	err := store.drain(ctx)
+
+	store.writeSem <- struct{}{}
+	store.mu.Lock()
+	if store.closed {
+		store.mu.Unlock()
+		<-store.writeSem
+		return err
+	}
+	store.closed = true
+	close(store.wake)
+	store.mu.Unlock()
+	<-store.writeSem
+
+	if uerr := store.unregisterAll(ctx); err == nil {
+		err = uerr
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if c, ok := store.rawBackend().(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// drain waits until no event is in flight
+func (store *EventStore) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&store.inFlight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// unregisterAll removes every listener and waits for the ones in the middle
+// of handling an event to finish it
+func (store *EventStore) unregisterAll(ctx context.Context) error {
+	store.listenersMu.Lock()
+	registrations := store.routes.registrations
+	store.routes = newRoutes(nil)
+	store.listenersMu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		for _, r := range registrations {
+			r.stop()
+			r.wg.Wait()
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownLosesNoAcknowledgedEvent(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Run()
+	// every request is answered with an event of its own, as the game's handlers do
+	s.Register(NewFilteredEventHandler(Filter{EventTypes: []int{1}}, func(s *EventStore, e Event) {
+		time.Sleep(100 * time.Microsecond)
+		if _, err := s.Persist(context.Background(), Event{AggregateID: e.AggregateID, EventType: 2, Metadata: CausedBy(e)}); err != nil {
+			t.Error("expected the answer to", e, "to be appended while draining but got", err)
+		}
+	}))
+
+	var (
+		mu           sync.Mutex
+		acknowledged []int
+		wg           sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ev, err := s.Persist(context.Background(), Event{AggregateID: "some game", EventType: 1})
+				if err == ErrClosed {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				acknowledged = append(acknowledged, ev.Id)
+				mu.Unlock()
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if s.Listeners() != 0 {
+		t.Error("expected every listener to be unregistered but", s.Listeners(), "are left")
+	}
+	if _, err := s.Persist(context.Background(), Event{AggregateID: "some game", EventType: 1}); err != ErrClosed {
+		t.Error("expected a closed store to refuse events but got", err)
+	}
+
+	restarted, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := map[int]bool{}
+	found := map[int]bool{}
+	for _, ev := range restarted.Events() {
+		found[ev.Id] = true
+		if ev.EventType == 2 {
+			answered[*ev.Metadata.CausationID] = true
+		}
+	}
+	if len(acknowledged) == 0 {
+		t.Fatal("expected some events to be acknowledged before the shutdown")
+	}
+	for _, id := range acknowledged {
+		if !found[id] || !answered[id] {
+			t.Fatal("expected acknowledged event", id, "to be in the log and answered, in the log:", found[id])
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Snapshot is the state of an aggregate after its first Moves moves,
+// EventID is the Id of the event holding the last of them.
+// Stores whose backend is a SnapshotBackend keep them next to the log so
+// games load from them after a restart, other stores only keep them in memory.
+type Snapshot struct {
+	AggregateID string
+	EventID     int
+	Moves       int
+	FEN         string
+	MoveList    []string
+	// RepetitionFEN is the position after the last capture or pawn move and
+	// Since the moves played after it, a game is restored by replaying them
+	// so repetitions are still counted
+	RepetitionFEN string
+	Since         []string
+}
+
+func (s Snapshot) String() string {
+	return fmt.Sprintf("Snapshot<AggregateID: %s, EventID: %d, Moves: %d, FEN: %s>",
+		s.AggregateID, s.EventID, s.Moves, s.FEN)
+}
+
+// SnapshotBackend is a Backend that keeps snapshots, so they outlive a restart
+type SnapshotBackend interface {
+	// LoadSnapshots returns the snapshots saved and not dropped since
+	LoadSnapshots() ([]Snapshot, error)
+	SaveSnapshot(s Snapshot) error
+	DropSnapshots(aggregateID string, moves int) error
+}
+
+// loadSnapshots takes the snapshots the backend kept
+func (store *EventStore) loadSnapshots() {
+	b, ok := store.rawBackend().(SnapshotBackend)
+	if !ok {
+		return
+	}
+	snapshots, err := b.LoadSnapshots()
+	if err != nil {
+		log.Println("failed to load snapshots:", err)
+		return
+	}
+	store.snapshotsMu.Lock()
+	defer store.snapshotsMu.Unlock()
+	for _, s := range snapshots {
+		saveSnapshot(store.snapshots, s)
+	}
+}
+
+// Snapshots returns the snapshots of an aggregate ordered by Moves
+func (store *EventStore) Snapshots(aggregateID string) []Snapshot {
+	store.snapshotsMu.RLock()
+	defer store.snapshotsMu.RUnlock()
+	snapshots := store.snapshots[aggregateID]
+	return snapshots[:len(snapshots):len(snapshots)]
+}
+
+// SaveSnapshot stores s, replacing any snapshot of the same aggregate at the same Moves
+func (store *EventStore) SaveSnapshot(s Snapshot) {
+	store.snapshotsMu.Lock()
+	defer store.snapshotsMu.Unlock()
+	saveSnapshot(store.snapshots, s)
+	if b, ok := store.rawBackend().(SnapshotBackend); ok {
+		if err := b.SaveSnapshot(s); err != nil {
+			log.Println("failed to save snapshot:", s, err)
+		}
+	}
+}
+
+// DropSnapshots removes the snapshots of an aggregate that cover more than moves moves
+func (store *EventStore) DropSnapshots(aggregateID string, moves int) {
+	store.snapshotsMu.Lock()
+	defer store.snapshotsMu.Unlock()
+	if !dropSnapshots(store.snapshots, aggregateID, moves) {
+		return
+	}
+	if b, ok := store.rawBackend().(SnapshotBackend); ok {
+		if err := b.DropSnapshots(aggregateID, moves); err != nil {
+			log.Println("failed to drop snapshots of", aggregateID, err)
+		}
+	}
+}
+
+func saveSnapshot(all map[string][]Snapshot, s Snapshot) {
+	var snapshots []Snapshot
+	for _, old := range all[s.AggregateID] {
+		if old.Moves != s.Moves {
+			snapshots = append(snapshots, old)
+		}
+	}
+	snapshots = append(snapshots, s)
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Moves < snapshots[j].Moves
+	})
+	all[s.AggregateID] = snapshots
+}
+
+// dropSnapshots tells whether there were snapshots to drop
+func dropSnapshots(all map[string][]Snapshot, aggregateID string, moves int) bool {
+	snapshots := all[aggregateID]
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].Moves > moves
+	})
+	if i == len(snapshots) {
+		return false
+	}
+	if i == 0 {
+		delete(all, aggregateID)
+		return true
+	}
+	all[aggregateID] = snapshots[:i:i]
+	return true
+}
+
+// snapshotsFile is where a FileBackend keeps its snapshots, next to the log's segments
+const snapshotsFile = "snapshots.jsonl"
+
+// snapshotRecord is a line of the snapshots file, either a snapshot saved or
+// the snapshots of an aggregate past Moves dropped
+type snapshotRecord struct {
+	Snapshot
+	Drop bool `json:",omitempty"`
+}
+
+// LoadSnapshots reads the snapshots file and rewrites it with only the
+// snapshots still kept. Snapshots can be rebuilt from the log so the file
+// isn't synced, a line torn by a crash is skipped.
+func (b *FileBackend) LoadSnapshots() ([]Snapshot, error) {
+	snapshots, err := readSnapshots(b.log.dir)
+	if err != nil {
+		return nil, err
+	}
+	var kept bytes.Buffer
+	enc := json.NewEncoder(&kept)
+	for _, s := range snapshots {
+		if err := enc.Encode(snapshotRecord{Snapshot: s}); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(b.log.dir, snapshotsFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, kept.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return snapshots, os.Rename(tmp, path)
+}
+
+// readSnapshots returns the snapshots kept in the snapshots file of dir
+func readSnapshots(dir string) ([]Snapshot, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	all := map[string][]Snapshot{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var r snapshotRecord
+		if len(line) == 0 || json.Unmarshal(line, &r) != nil {
+			continue
+		}
+		if r.Drop {
+			dropSnapshots(all, r.AggregateID, r.Moves)
+		} else {
+			saveSnapshot(all, r.Snapshot)
+		}
+	}
+	var snapshots []Snapshot
+	for _, aggregate := range all {
+		snapshots = append(snapshots, aggregate...)
+	}
+	return snapshots, nil
+}
+
+func (b *FileBackend) SaveSnapshot(s Snapshot) error {
+	return b.writeSnapshotRecord(snapshotRecord{Snapshot: s})
+}
+
+func (b *FileBackend) DropSnapshots(aggregateID string, moves int) error {
+	return b.writeSnapshotRecord(snapshotRecord{Snapshot: Snapshot{AggregateID: aggregateID, Moves: moves}, Drop: true})
+}
+
+func (b *FileBackend) writeSnapshotRecord(r snapshotRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(b.log.dir, snapshotsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// LoadSnapshots reads the snapshots file as it is
+func (b *readOnlyFileBackend) LoadSnapshots() ([]Snapshot, error) {
+	return readSnapshots(b.dir)
+}
+
+// SaveSnapshot keeps nothing, the store holds its snapshots in memory
+func (b *readOnlyFileBackend) SaveSnapshot(Snapshot) error {
+	return nil
+}
+
+func (b *readOnlyFileBackend) DropSnapshots(string, int) error {
+	return nil
+}
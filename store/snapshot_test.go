@@ -0,0 +1,39 @@
+package store
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileEventStoreKeepsSnapshots(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileEventStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, moves := range []int{20, 40, 60} {
+		s.SaveSnapshot(Snapshot{AggregateID: "game", Moves: moves, EventID: moves - 1, FEN: "fen", Since: []string{"6-21"}})
+	}
+	s.SaveSnapshot(Snapshot{AggregateID: "other", Moves: 20, EventID: 100})
+	s.DropSnapshots("game", 45)
+	s.DropSnapshots("other", 0)
+	want := s.Snapshots("game")
+	s.rawBackend().(*FileBackend).Close()
+
+	for i := 0; i < 2; i++ {
+		restarted, err := NewFileEventStore(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := restarted.Snapshots("game"); len(got) != 2 || !reflect.DeepEqual(got, want) {
+			t.Error("expected the snapshots", want, "after a restart but got", got)
+		}
+		if got := restarted.Snapshots("other"); len(got) != 0 {
+			t.Error("expected the dropped snapshots to stay dropped but got", got)
+		}
+		restarted.rawBackend().(*FileBackend).Close()
+	}
+}
@@ -0,0 +1,36 @@
+package store
+
+// ListenerStats is how far behind a listener is
+type ListenerStats struct {
+	Name    string
+	Workers int
+	// Queued is how many events wait in the queues of the listener's workers
+	Queued int
+}
+
+// ListenerStats returns the stats of every registered listener in the order they registered
+func (store *EventStore) ListenerStats() []ListenerStats {
+	store.listenersMu.RLock()
+	registrations := store.routes.registrations
+	store.listenersMu.RUnlock()
+	stats := make([]ListenerStats, len(registrations))
+	for i, r := range registrations {
+		stats[i] = ListenerStats{Name: r.listener.name(), Workers: len(r.queues)}
+		for _, queue := range r.queues {
+			stats[i].Queued += len(queue)
+		}
+	}
+	return stats
+}
+
+// Appended returns how many events of every type were appended
+// or replicated since the store was opened
+func (store *EventStore) Appended() map[int]int64 {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	appended := make(map[int]int64, len(store.appended))
+	for t, n := range store.appended {
+		appended[t] = n
+	}
+	return appended
+}
@@ -1,82 +1,409 @@
 package store
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
+// EventStore appends events to its Backend and notifies its listeners of them.
+// Events are appended in the order Persist is called and delivered to every
+// listener in the order they were appended.
 type EventStore struct {
-	mu           sync.RWMutex
-	events       []Event
-	eventsCh     chan Event
-	registerCh   chan *EventListener
-	unregisterCh chan *EventListener
-	listeners    []*EventListener
+	mu      sync.RWMutex
+	backend Backend
+	// writeSem serializes appends, unlike mu waiting on it can be cancelled
+	writeSem chan struct{}
+
+	// pending holds appended events that weren't delivered to listeners yet,
+	// it's filled while holding mu so it's always in append order
+	pendingMu sync.Mutex
+	pending   []delivery
+	wake      chan struct{}
+
+	listenersMu sync.RWMutex
+	// routes is replaced rather than changed so dispatch can keep using a stale one
+	routes *routes
+
+	snapshotsMu sync.RWMutex
+	snapshots   map[string][]Snapshot
+
+	deadMu      sync.Mutex
+	deadLetters []DeadLetter
+
+	// clock stamps events, it's only swapped out by tests
+	clock func() time.Time
+	types *TypeRegistry
+	// idempotency and the schedules of scheduler are guarded by mu
+	idempotency *idempotency
+	scheduler   *scheduler
+	// archived holds the aggregates that were archived, it's guarded by mu
+	archived map[string]bool
+	// appended counts the events appended by type, it's guarded by mu
+	appended map[int]int64
+	// readOnly stores only take events through Replicate
+	readOnly bool
+
+	// inFlight counts the events waiting to be dispatched or handled by a listener
+	inFlight int64
+	// closing and closed are set by Shutdown, they're guarded by mu
+	closing bool
+	closed  bool
+	// done is closed by Shutdown to stop the scheduler
+	done     chan struct{}
+	doneOnce sync.Once
 }
 
+// ErrReadOnly is returned when appending to a read-only store
+var ErrReadOnly = errors.New("store is read-only")
+
+// ErrClosed is returned when appending to a store that was shut down
+var ErrClosed = errors.New("store is closed")
+
+// NewEventStore returns a store that keeps its events in memory
 func NewEventStore() *EventStore {
-	var c EventStore
+	return NewBackendEventStore(NewMemoryBackend(), nil)
+}
+
+// NewBackendEventStore returns a store that reads and writes its events through b.
+// With types set it stamps the events it appends with their type name and
+// schema version and upcasts the events it reads, those it starts off with included.
+func NewBackendEventStore(b Backend, types *TypeRegistry) *EventStore {
+	if types != nil {
+		b = newTypedBackend(b, types)
+	}
+	store := &EventStore{
+		backend:     b,
+		types:       types,
+		writeSem:    make(chan struct{}, 1),
+		wake:        make(chan struct{}, 1),
+		routes:      newRoutes(nil),
+		clock:       time.Now,
+		idempotency: newIdempotency(DefaultIdempotencyWindow),
+		scheduler:   newScheduler(types),
+		snapshots:   map[string][]Snapshot{},
+		archived:    map[string]bool{},
+		appended:    map[int]int64{},
+		done:        make(chan struct{}),
+	}
+	store.replay()
+	store.loadSnapshots()
+	return store
+}
+
+// NewFileEventStore returns a store that writes every event through to a
+// FileLog in dir and starts off with the events already there, types are as
+// for NewBackendEventStore.
+func NewFileEventStore(dir string, types *TypeRegistry) (*EventStore, error) {
+	b, err := OpenFileBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewBackendEventStore(b, types), nil
+}
+
+// NewReadOnlyFileEventStore returns a read-only store holding the events of
+// the log in dir and its snapshots. Nothing in dir is changed, so it's safe
+// beside a server that has the log open. It fails like ReadFileLog, with a
+// *CorruptTailError the store holds the events before the tail.
+func NewReadOnlyFileEventStore(dir string, types *TypeRegistry) (*EventStore, error) {
+	events, err := ReadFileLog(dir)
+	if _, ok := err.(*CorruptTailError); err != nil && !ok {
+		return nil, err
+	}
+	mem := NewMemoryBackend()
+	for _, ev := range events {
+		mem.Append(ev)
+	}
+	store := NewBackendEventStore(&readOnlyFileBackend{MemoryBackend: mem, dir: dir}, types)
+	store.SetReadOnly()
+	return store, err
+}
+
+// replay takes the state the store keeps besides its events from the events the backend starts off with
+func (store *EventStore) replay() {
+	events, err := store.backend.ReadAll()
+	if err != nil {
+		log.Println("failed to read events:", err)
+		return
+	}
+	for _, ev := range events {
+		store.idempotency.remember(ev)
+		store.scheduler.replay(ev)
+		store.replayArchive(ev)
+	}
+}
+
+// SetReadOnly makes the store refuse to persist events and fire schedules, so
+// it only holds the events it's handed through Replicate. It must be called
+// before the store is used.
+func (store *EventStore) SetReadOnly() {
+	store.readOnly = true
+}
 
-	return &c
+func (store *EventStore) ReadOnly() bool {
+	return store.readOnly
+}
+
+// LastID returns the Id of the last appended event or -1 if there are none
+func (store *EventStore) LastID() int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.backend.LastID()
 }
 
 func (store *EventStore) Events() []Event {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
-	return store.events
+	events, err := store.backend.ReadAll()
+	if err != nil {
+		log.Println("failed to read events:", err)
+	}
+	return events
+}
+
+// ReadStream returns the events of a single aggregate, skipping the first fromVersion of them
+func (store *EventStore) ReadStream(aggregateID string, fromVersion int) []Event {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	events, err := store.backend.ReadStream(aggregateID, fromVersion)
+	if err != nil {
+		log.Println("failed to read stream:", aggregateID, err)
+	}
+	return events
+}
+
+// AddEvent appends the event without notifying listeners
+func (store *EventStore) AddEvent(ev Event) (Event, error) {
+	return store.addEvent(context.Background(), ev, AnyVersion, false)
 }
-func (store *EventStore) AddEvent(ev Event) {
+
+func (store *EventStore) addEvent(ctx context.Context, ev Event, expectedVersion int, notify bool) (Event, error) {
+	if store.readOnly {
+		return ev, ErrReadOnly
+	}
+	select {
+	case store.writeSem <- struct{}{}:
+	case <-ctx.Done():
+		return ev, ctx.Err()
+	}
+	defer func() { <-store.writeSem }()
+
 	store.mu.Lock()
 	defer store.mu.Unlock()
-	ev.Id = store.nextID(store.events)
-	store.events = append(store.events, ev)
+	return store.appendLocked(ev, expectedVersion, notify)
 }
 
-func (store *EventStore) Run() {
-	store.eventsCh = make(chan Event)
-	store.registerCh = make(chan *EventListener)
-	store.unregisterCh = make(chan *EventListener)
+// appendLocked does the work of addEvent, it must be called holding writeSem and mu
+func (store *EventStore) appendLocked(ev Event, expectedVersion int, notify bool) (Event, error) {
+	if store.closed || (store.closing && ev.Metadata.CausationID == nil) {
+		return ev, ErrClosed
+	}
+	if original, ok := store.idempotency.lookup(ev, store.clock()); ok {
+		return original, nil
+	}
+	if store.archived[ev.AggregateID] {
+		return ev, ErrArchived
+	}
+	stream, err := store.backend.ReadStream(ev.AggregateID, 0)
+	if err != nil {
+		return ev, err
+	}
+	if expectedVersion != AnyVersion && expectedVersion != len(stream) {
+		return ev, &ConflictError{AggregateID: ev.AggregateID, Expected: expectedVersion, Actual: len(stream)}
+	}
+	ev.Id = store.backend.LastID() + 1
+	ev.Version = len(stream) + 1
+	if ev.Metadata.Timestamp.IsZero() {
+		ev.Metadata.Timestamp = store.clock().UTC()
+	}
+	if ev.Metadata.CorrelationID == "" {
+		ev.Metadata.CorrelationID = strconv.Itoa(ev.Id)
+	}
+	if store.types != nil {
+		if ev, err = store.types.stamp(ev); err != nil {
+			return ev, err
+		}
+	}
+	if ev, err = store.chain(ev); err != nil {
+		return ev, err
+	}
+	if err := store.backend.Append(ev); err != nil {
+		return ev, err
+	}
+	store.appended[ev.EventType]++
+	store.idempotency.remember(ev)
+	store.scheduler.replay(ev)
+	store.replayArchive(ev)
+	if notify {
+		store.enqueue(ev)
+	}
+	return ev, nil
+}
+
+// delivery is an event waiting to be dispatched,
+// to every listener or only to the one it's addressed to
+type delivery struct {
+	event Event
+	to    *registration
+}
+
+func (store *EventStore) enqueue(ev Event) {
+	store.enqueueTo(ev, nil)
+}
+
+func (store *EventStore) enqueueTo(ev Event, to *registration) {
+	store.track(1)
+	store.pendingMu.Lock()
+	store.pending = append(store.pending, delivery{event: ev, to: to})
+	store.pendingMu.Unlock()
+	select {
+	case store.wake <- struct{}{}:
+	default:
+	}
+}
 
+func (store *EventStore) takePending() []delivery {
+	store.pendingMu.Lock()
+	defer store.pendingMu.Unlock()
+	deliveries := store.pending
+	store.pending = nil
+	return deliveries
+}
+
+// Run starts handing appended events to the listeners' queues and firing schedules
+func (store *EventStore) Run() {
+	go store.runScheduler()
 	go func() {
-		for {
-			select {
-			case e := <-store.eventsCh:
-				store.AddEvent(e)
-				for _, s := range store.listeners {
-					s.notify(store, e)
-				}
-			case reg := <-store.registerCh:
-				store.listeners = append(store.listeners, reg)
-			case unreg := <-store.unregisterCh:
-				for i := range store.listeners {
-					if unreg == store.listeners[i] {
-						store.listeners = append(store.listeners[:i], store.listeners[i+1:]...)
-						break
-					}
+		for range store.wake {
+			for _, d := range store.takePending() {
+				if d.to != nil {
+					d.to.push(store, d.event)
+				} else {
+					store.dispatch(d.event)
 				}
+				store.track(-1)
 			}
 		}
 	}()
 }
 
-func (store *EventStore) nextID(events []Event) int {
-	if len(events) == 0 {
-		return 0
+func (store *EventStore) dispatch(e Event) {
+	store.listenersMu.RLock()
+	r := store.routes
+	store.listenersMu.RUnlock()
+	store.pushAll(r.any, e)
+	store.pushAll(r.byAggregate[e.AggregateID], e)
+}
+
+func (store *EventStore) pushAll(registrations []*registration, e Event) {
+	for _, r := range registrations {
+		// a listener only gets the events appended after it registered,
+		// it was handed the ones before that when it subscribed
+		if e.Id > r.after && r.matchType(e) && atomic.LoadInt32(&r.removed) == 0 {
+			r.push(store, e)
+		}
 	}
-	return events[len(events)-1].Id + 1
 }
 
-func (store *EventStore) Persist(e Event) {
-	go func() {
-		store.eventsCh <- e
-	}()
+// Persist blocks until the event is appended, or ctx is done before it could be,
+// and returns it with its Id and Version assigned.
+// Listeners are notified of the event asynchronously.
+//
+// An event with an idempotency key that was already used for its aggregate
+// within the idempotency window isn't appended again, the event appended with
+// the key the first time is returned instead.
+func (store *EventStore) Persist(ctx context.Context, e Event) (Event, error) {
+	return store.PersistExpected(ctx, e, AnyVersion)
 }
 
+// PersistExpected is like Persist but only appends the event as long as its
+// aggregate is still at expectedVersion and returns a *ConflictError otherwise.
+func (store *EventStore) PersistExpected(ctx context.Context, e Event, expectedVersion int) (Event, error) {
+	return store.addEvent(ctx, e, expectedVersion, true)
+}
+
+// Register adds a listener, once it returns the listener is notified
+// of every event appended from then on that matches its Filter
 func (store *EventStore) Register(s *EventListener) {
-	go func() {
-		store.registerCh <- s
-	}()
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	store.register(newRegistration(s, store.backend.LastID()))
 }
 
+// register must be called holding mu so no event gets appended
+// between taking the listener's starting point and adding it
+func (store *EventStore) register(r *registration) {
+	r.start(store)
+	store.listenersMu.Lock()
+	defer store.listenersMu.Unlock()
+	registrations := make([]*registration, len(store.routes.registrations), len(store.routes.registrations)+1)
+	copy(registrations, store.routes.registrations)
+	store.routes = newRoutes(append(registrations, r))
+}
+
+// Unregister removes a listener, once it returns the listener won't be
+// notified of any more events other than the ones its workers may be in the middle of handling
 func (store *EventStore) Unregister(s *EventListener) {
-	go func() {
-		store.unregisterCh <- s
-	}()
+	store.listenersMu.Lock()
+	defer store.listenersMu.Unlock()
+	for i, r := range store.routes.registrations {
+		if s == r.listener {
+			r.stop()
+			registrations := make([]*registration, 0, len(store.routes.registrations)-1)
+			registrations = append(registrations, store.routes.registrations[:i]...)
+			store.routes = newRoutes(append(registrations, store.routes.registrations[i+1:]...))
+			break
+		}
+	}
+}
+
+// Listeners returns the number of registered listeners
+func (store *EventStore) Listeners() int {
+	store.listenersMu.RLock()
+	defer store.listenersMu.RUnlock()
+	return len(store.routes.registrations)
+}
+
+// Import appends an event exactly as it's given, Id, Version, metadata and
+// hashes included, as when loading events exported from another store.
+// Its Id has to come after the last event's and listeners aren't notified.
+func (store *EventStore) Import(ev Event) error {
+	return store.importEvent(ev, false)
+}
+
+// Replicate is like Import but notifies listeners of the event,
+// it's how a follower takes the events of its leader
+func (store *EventStore) Replicate(ev Event) error {
+	return store.importEvent(ev, true)
+}
+
+func (store *EventStore) importEvent(ev Event, notify bool) error {
+	store.writeSem <- struct{}{}
+	defer func() { <-store.writeSem }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closing {
+		return ErrClosed
+	}
+	if last := store.backend.LastID(); ev.Id <= last {
+		return fmt.Errorf("event %d doesn't come after the last event %d", ev.Id, last)
+	}
+	if err := store.backend.Append(ev); err != nil {
+		return err
+	}
+	store.appended[ev.EventType]++
+	store.idempotency.remember(ev)
+	store.scheduler.replay(ev)
+	store.replayArchive(ev)
+	if notify {
+		store.enqueue(ev)
+	}
+	return nil
 }
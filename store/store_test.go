@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPersistExpected(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	ctx := context.Background()
+	if _, err := s.PersistExpected(ctx, Event{AggregateID: "some game", EventType: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PersistExpected(ctx, Event{AggregateID: "other game", EventType: 1}, 0); err != nil {
+		t.Fatal("expected versions to be per aggregate but failed with", err)
+	}
+	if _, err := s.PersistExpected(ctx, Event{AggregateID: "some game", EventType: 2}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.PersistExpected(ctx, Event{AggregateID: "some game", EventType: 2}, 1)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatal("expected a conflict persisting against a stale version but got", err)
+	}
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Error("expected conflict between versions 1 and 2 but got", conflict)
+	}
+
+	stream := s.ReadStream("some game", 0)
+	if len(stream) != 2 || stream[0].Version != 1 || stream[1].Version != 2 {
+		t.Error("expected the rejected event to leave the stream untouched but got", stream)
+	}
+}
+
+func TestVersionedPersister(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	p := &VersionedPersister{Store: s}
+	if err := p.Persist(Event{AggregateID: "some game"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Persist(Event{AggregateID: "some game"}); err != nil {
+		t.Error("expected the persister to follow its own events but got", err)
+	}
+
+	stale := &VersionedPersister{Store: s, Version: 1}
+	if err := stale.Persist(Event{AggregateID: "some game"}); err == nil {
+		t.Error("expected a stale persister to be rejected")
+	}
+}
+
+func TestPersistAssignsID(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	for i := 0; i < 3; i++ {
+		ev, err := s.Persist(context.Background(), Event{AggregateID: "some game", EventData: "12-20"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Id != i || ev.Version != i+1 {
+			t.Error("expected event", i, "to get Id", i, "and Version", i+1, "but got", ev)
+		}
+		if events := s.Events(); len(events) != i+1 {
+			t.Error("expected the event to be stored once Persist returns but got", events)
+		}
+	}
+}
+
+func TestPersistDeadline(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	// hold the write lock so Persist can't get its turn
+	s.writeSem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Persist(ctx, Event{AggregateID: "some game"}); err != context.DeadlineExceeded {
+		t.Error("expected Persist to give up at the deadline but got", err)
+	}
+	<-s.writeSem
+
+	if events := s.Events(); len(events) != 0 {
+		t.Error("expected nothing to be stored but got", events)
+	}
+}
+
+// collector is a listener that records every event it's notified of
+type collector struct {
+	mu     sync.Mutex
+	events []Event
+	done   chan struct{}
+	want   int
+}
+
+func newCollector(want int) *collector {
+	return &collector{done: make(chan struct{}), want: want}
+}
+
+func (c *collector) listener() *EventListener {
+	return NewEventHandler(func(_ *EventStore, e Event) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.events = append(c.events, e)
+		if len(c.events) == c.want {
+			close(c.done)
+		}
+	})
+}
+
+func (c *collector) wait(t *testing.T) []Event {
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		t.Fatal("timed out waiting for", c.want, "events, received", len(c.events))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.events
+}
+
+func TestPersistOrder(t *testing.T) {
+	const (
+		writers   = 8
+		perWriter = 200
+	)
+	s := NewEventStore()
+	s.Run()
+	c := newCollector(writers * perWriter)
+	s.Register(c.listener())
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				e := Event{AggregateID: fmt.Sprintf("game %d", w%3), EventData: fmt.Sprintf("%d-%d", w, i)}
+				if _, err := s.Persist(context.Background(), e); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// every writer's events must be appended in the order it persisted them
+	next := make([]int, writers)
+	for _, e := range s.Events() {
+		var w, i int
+		fmt.Sscanf(e.EventData, "%d-%d", &w, &i)
+		if i != next[w] {
+			t.Fatalf("writer %d persisted event %d but event %d was appended next", w, next[w], i)
+		}
+		next[w]++
+	}
+
+	// and delivered in the order they were appended
+	delivered := c.wait(t)
+	for i, e := range delivered {
+		if e.Id != i {
+			t.Fatalf("expected event %d to be delivered in position %d but got %v", i, i, e)
+		}
+	}
+}
+
+func TestRegisterBeforePersist(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gameID := fmt.Sprintf("game %d", i)
+			received := make(chan struct{})
+			l := NewEventHandler(func(_ *EventStore, e Event) {
+				if e.AggregateID == gameID {
+					close(received)
+				}
+			})
+			s.Register(l)
+			defer s.Unregister(l)
+
+			if _, err := s.Persist(context.Background(), Event{AggregateID: gameID}); err != nil {
+				t.Error(err)
+				return
+			}
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Error("listener registered before Persist never received the event of", gameID)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestListenerOnlyGetsEventsAfterRegister(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+	before, _ := s.Persist(context.Background(), Event{AggregateID: "some game"})
+
+	c := newCollector(1)
+	l := c.listener()
+	s.Register(l)
+	after, _ := s.Persist(context.Background(), Event{AggregateID: "some game"})
+
+	if events := c.wait(t); events[0].Id != after.Id {
+		t.Error("expected to only receive", after, "but received", events, "which includes", before)
+	}
+
+	s.Unregister(l)
+	s.Persist(context.Background(), Event{AggregateID: "some game"})
+	// give a wrongly delivered event the chance to show up
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.events) != 1 {
+		t.Error("expected no events after Unregister but received", c.events)
+	}
+}
+
+// eventually fails the test if cond doesn't become true within a few seconds
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
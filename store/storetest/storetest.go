@@ -0,0 +1,117 @@
+// Package storetest holds the conformance tests every store.Backend must pass.
+package storetest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scottcarol/go-chess/store"
+)
+
+// TestBackend runs the conformance suite against backends returned by newBackend,
+// which must return a new, empty backend on every call.
+func TestBackend(t *testing.T, newBackend func(t *testing.T) store.Backend) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, store.Backend)
+	}{
+		{"Empty", testEmpty},
+		{"AppendReadAll", testAppendReadAll},
+		{"ReadStream", testReadStream},
+		{"ReadAllIsStable", testReadAllIsStable},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newBackend(t))
+		})
+	}
+}
+
+func sampleEvents() []store.Event {
+	return []store.Event{
+		{Id: 0, AggregateID: "some game", EventType: 1, EventData: "12-20"},
+		{Id: 1, AggregateID: "other game", EventType: 1, EventData: "12-28"},
+		{Id: 2, AggregateID: "some game", EventType: 2, EventData: "12-20"},
+		{Id: 3, AggregateID: "some game", EventType: 1, EventData: "52-36"},
+		{Id: 4, AggregateID: "other game", EventType: 2, EventData: "12-28"},
+	}
+}
+
+func appendAll(t *testing.T, b store.Backend, events []store.Event) {
+	for _, ev := range events {
+		if err := b.Append(ev); err != nil {
+			t.Fatal("failed to append", ev, err)
+		}
+	}
+}
+
+func testEmpty(t *testing.T, b store.Backend) {
+	if id := b.LastID(); id != -1 {
+		t.Error("expected LastID of an empty backend to be -1 but got", id)
+	}
+	if events, err := b.ReadAll(); err != nil || len(events) != 0 {
+		t.Error("expected no events but received", events, err)
+	}
+	if events, err := b.ReadStream("some game", 0); err != nil || len(events) != 0 {
+		t.Error("expected no events but received", events, err)
+	}
+}
+
+func testAppendReadAll(t *testing.T, b store.Backend) {
+	expected := sampleEvents()
+	appendAll(t, b, expected)
+
+	events, err := b.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Error("expected", expected, "but received", events)
+	}
+	if id := b.LastID(); id != 4 {
+		t.Error("expected LastID 4 but got", id)
+	}
+}
+
+func testReadStream(t *testing.T, b store.Backend) {
+	events := sampleEvents()
+	appendAll(t, b, events)
+
+	testCases := []struct {
+		aggregateID string
+		fromVersion int
+		expected    []store.Event
+	}{
+		{"some game", 0, []store.Event{events[0], events[2], events[3]}},
+		{"some game", 2, []store.Event{events[3]}},
+		{"some game", 3, nil},
+		{"other game", 1, []store.Event{events[4]}},
+		{"no such game", 0, nil},
+	}
+	for _, tc := range testCases {
+		stream, err := b.ReadStream(tc.aggregateID, tc.fromVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stream) != len(tc.expected) || (len(stream) > 0 && !reflect.DeepEqual(stream, tc.expected)) {
+			t.Error("reading", tc.aggregateID, "from", tc.fromVersion, "expected", tc.expected, "but received", stream)
+		}
+	}
+}
+
+func testReadAllIsStable(t *testing.T, b store.Backend) {
+	events := sampleEvents()
+	appendAll(t, b, events[:2])
+
+	read, err := b.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read = append(read, store.Event{Id: 100})
+	appendAll(t, b, events[2:])
+
+	if all, _ := b.ReadAll(); !reflect.DeepEqual(all, events) {
+		t.Error("appending to a ReadAll result changed the backend, expected", events, "but received", all)
+	}
+}
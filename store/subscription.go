@@ -0,0 +1,88 @@
+package store
+
+import (
+	"sort"
+)
+
+// Filter selects the events a listener is notified of.
+// An empty field matches every event.
+type Filter struct {
+	AggregateIDs []string
+	EventTypes   []int
+}
+
+func (f Filter) Match(e Event) bool {
+	return (len(f.AggregateIDs) == 0 || containsString(f.AggregateIDs, e.AggregateID)) &&
+		(len(f.EventTypes) == 0 || containsInt(f.EventTypes, e.EventType))
+}
+
+// Subscribe registers a listener that is first notified of the events appended
+// after fromID that match its Filter and then of every matching event appended
+// from then on, each of them exactly once.
+// Pass -1 as fromID to start from the first event.
+func (store *EventStore) Subscribe(s *EventListener, fromID int) error {
+	// appends have to wait until the listener is in place,
+	// otherwise an event could land between the history and the live events
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.closed {
+		return ErrClosed
+	}
+
+	filter := s.Filter
+	history, err := store.history(filter)
+	if err != nil {
+		return err
+	}
+	r := newRegistration(s, store.backend.LastID())
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].Id > fromID
+	})
+	for _, e := range history[i:] {
+		if filter.Match(e) {
+			store.enqueueTo(e, r)
+		}
+	}
+	store.register(r)
+	return nil
+}
+
+// history returns every event filter could match in append order,
+// reading only the streams it's limited to if there are any
+func (store *EventStore) history(filter Filter) ([]Event, error) {
+	if len(filter.AggregateIDs) == 0 {
+		return store.backend.ReadAll()
+	}
+	var events []Event
+	for _, id := range filter.AggregateIDs {
+		stream, err := store.backend.ReadStream(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, stream...)
+	}
+	if len(filter.AggregateIDs) > 1 {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].Id < events[j].Id
+		})
+	}
+	return events, nil
+}
+
+func containsString(strs []string, s string) bool {
+	for i := range strs {
+		if strs[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(ints []int, n int) bool {
+	for i := range ints {
+		if ints[i] == n {
+			return true
+		}
+	}
+	return false
+}
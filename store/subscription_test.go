@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	e := Event{AggregateID: "some game", EventType: 2}
+	testCases := []struct {
+		filter   Filter
+		expected bool
+	}{
+		{Filter{}, true},
+		{Filter{AggregateIDs: []string{"other game", "some game"}}, true},
+		{Filter{AggregateIDs: []string{"other game"}}, false},
+		{Filter{EventTypes: []int{1, 2}}, true},
+		{Filter{EventTypes: []int{1}}, false},
+		{Filter{AggregateIDs: []string{"some game"}, EventTypes: []int{1}}, false},
+	}
+	for _, tc := range testCases {
+		if tc.filter.Match(e) != tc.expected {
+			t.Error("expected", tc.filter, "matching", e, "to be", tc.expected)
+		}
+	}
+}
+
+func TestSubscribeCatchesUp(t *testing.T) {
+	const (
+		history = 50
+		live    = 200
+	)
+	s := NewEventStore()
+	s.Run()
+	for i := 0; i < history; i++ {
+		s.Persist(context.Background(), Event{AggregateID: fmt.Sprintf("game %d", i%2), EventType: i % 3})
+	}
+
+	filter := Filter{AggregateIDs: []string{"game 0"}}
+	var expected []Event
+	for _, e := range s.Events() {
+		if e.Id > 10 && filter.Match(e) {
+			expected = append(expected, e)
+		}
+	}
+
+	// persist concurrently with subscribing so live events race the history
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < live; i++ {
+			s.Persist(context.Background(), Event{AggregateID: fmt.Sprintf("game %d", i%2)})
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		received []Event
+	)
+	l := NewFilteredEventHandler(filter, func(_ *EventStore, e Event) {
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	})
+	if err := s.Subscribe(l, 10); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for _, e := range s.Events() {
+		if e.Id >= history && filter.Match(e) {
+			expected = append(expected, e)
+		}
+	}
+
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) >= len(expected)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected the %d events of game 0 after event 10 in order but received %d: %v", len(expected), len(received), received)
+	}
+}
+
+func TestFilteredListeners(t *testing.T) {
+	s := NewEventStore()
+	s.Run()
+
+	var (
+		mu       sync.Mutex
+		received = map[string][]Event{}
+	)
+	listen := func(name string, f Filter) {
+		s.Register(NewFilteredEventHandler(f, func(_ *EventStore, e Event) {
+			mu.Lock()
+			received[name] = append(received[name], e)
+			mu.Unlock()
+		}))
+	}
+	listen("all", Filter{})
+	listen("some game", Filter{AggregateIDs: []string{"some game", "some game"}})
+	listen("type 2", Filter{EventTypes: []int{2}})
+	listen("some game type 2", Filter{AggregateIDs: []string{"some game"}, EventTypes: []int{2}})
+
+	var events []Event
+	for _, e := range []Event{
+		{AggregateID: "some game", EventType: 1},
+		{AggregateID: "other game", EventType: 2},
+		{AggregateID: "some game", EventType: 2},
+	} {
+		e, _ = s.Persist(context.Background(), e)
+		events = append(events, e)
+	}
+
+	expected := map[string][]Event{
+		"all":              events,
+		"some game":        {events[0], events[2]},
+		"type 2":           {events[1], events[2]},
+		"some game type 2": {events[2]},
+	}
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for name := range expected {
+			if len(received[name]) < len(expected[name]) {
+				return false
+			}
+		}
+		return true
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for name := range expected {
+		if !reflect.DeepEqual(received[name], expected[name]) {
+			t.Error(name, "expected", expected[name], "but received", received[name])
+		}
+	}
+}
+
+// BenchmarkDispatch hands one event to a store with a listener per open game,
+// like the websocket of every player, showing that routing by aggregate keeps
+// the cost flat while filtering inside the callback grows with the games
+func BenchmarkDispatch(b *testing.B) {
+	for _, games := range []int{10, 100, 1000} {
+		for _, routed := range []bool{false, true} {
+			b.Run(fmt.Sprintf("games=%d/routed=%t", games, routed), func(b *testing.B) {
+				s := NewEventStore()
+				for g := 0; g < games; g++ {
+					gameID := fmt.Sprintf("game %d", g)
+					l := NewEventHandler(func(_ *EventStore, e Event) {
+						if e.AggregateID != gameID {
+							return
+						}
+					})
+					if routed {
+						l.Filter = Filter{AggregateIDs: []string{gameID}}
+					}
+					s.Register(l)
+				}
+				e := Event{Id: 1, AggregateID: "game 0"}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					s.dispatch(e)
+				}
+			})
+		}
+	}
+}
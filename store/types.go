@@ -0,0 +1,191 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Upcaster converts the data of an event from one schema version to the next
+type Upcaster func(data string) (string, error)
+
+// EventType describes the payload of one type of event.
+// Name is what's stored with the event, unlike the numeric type it must never change.
+type EventType struct {
+	Type    int
+	Name    string
+	Version int
+	// New returns a pointer to an empty payload for the current version
+	New func() interface{}
+	// Upcasters[v] converts data of schema version v to v+1,
+	// there has to be one for every version before the current
+	Upcasters map[int]Upcaster
+}
+
+// TypeRegistry knows the event types a store holds. With one set the store stamps
+// every event it appends with its type name and schema version, and upgrades
+// events of older schema versions to the current one as they're read.
+type TypeRegistry struct {
+	byType map[int]*EventType
+	byName map[string]*EventType
+}
+
+// NewTypeRegistry returns a registry that knows only the store's own event types
+func NewTypeRegistry() *TypeRegistry {
+	r := &TypeRegistry{byType: map[int]*EventType{}, byName: map[string]*EventType{}}
+	registerSystemTypes(r)
+	return r
+}
+
+// Register adds an event type, it panics if its type or name is already taken
+func (r *TypeRegistry) Register(t EventType) {
+	if _, ok := r.byType[t.Type]; ok {
+		panic(fmt.Sprintf("store: event type %d registered twice", t.Type))
+	}
+	if _, ok := r.byName[t.Name]; ok {
+		panic(fmt.Sprintf("store: event type name %s registered twice", t.Name))
+	}
+	for v := 1; v < t.Version; v++ {
+		if t.Upcasters[v] == nil {
+			panic(fmt.Sprintf("store: event type %s has no upcaster from version %d", t.Name, v))
+		}
+	}
+	r.byType[t.Type] = &t
+	r.byName[t.Name] = &t
+}
+
+// Lookup returns the registered type of an event
+func (r *TypeRegistry) Lookup(eventType int) (EventType, bool) {
+	t, ok := r.byType[eventType]
+	if !ok {
+		return EventType{}, false
+	}
+	return *t, true
+}
+
+// stamp sets the type name and schema version of an event that's about to be appended
+func (r *TypeRegistry) stamp(ev Event) (Event, error) {
+	t, ok := r.byType[ev.EventType]
+	if !ok {
+		return ev, fmt.Errorf("unknown event type %d", ev.EventType)
+	}
+	ev.TypeName = t.Name
+	if ev.SchemaVersion == 0 {
+		ev.SchemaVersion = t.Version
+	}
+	return ev, nil
+}
+
+// Upcast brings a stored event up to date. Its numeric type is taken from its type
+// name and its data is converted to the current schema version of that type.
+// Events stored before they had a type name are taken to be of version 1.
+func (r *TypeRegistry) Upcast(ev Event) (Event, error) {
+	t := r.byName[ev.TypeName]
+	if ev.TypeName == "" {
+		t = r.byType[ev.EventType]
+	}
+	if t == nil {
+		return ev, fmt.Errorf("unknown event type %d %q", ev.EventType, ev.TypeName)
+	}
+	ev.EventType, ev.TypeName = t.Type, t.Name
+	if ev.SchemaVersion == 0 {
+		ev.SchemaVersion = 1
+	}
+	for ev.SchemaVersion < t.Version {
+		data, err := t.Upcasters[ev.SchemaVersion](ev.EventData)
+		if err != nil {
+			return ev, fmt.Errorf("upcasting %s from version %d: %v", t.Name, ev.SchemaVersion, err)
+		}
+		ev.EventData = data
+		ev.SchemaVersion++
+	}
+	return ev, nil
+}
+
+// Decode returns the payload of an event as the type registered for it
+func (r *TypeRegistry) Decode(ev Event) (interface{}, error) {
+	t, ok := r.byType[ev.EventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %d", ev.EventType)
+	}
+	payload := t.New()
+	if err := json.Unmarshal([]byte(ev.EventData), payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (r *TypeRegistry) current(ev Event) bool {
+	t := r.byType[ev.EventType]
+	return ev.TypeName != "" && t != nil && t.Name == ev.TypeName && ev.SchemaVersion == t.Version
+}
+
+// EncodePayload returns the JSON encoding of a payload to be used as EventData
+func EncodePayload(payload interface{}) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(fmt.Sprintf("store: can't encode payload %#v: %v", payload, err))
+	}
+	return string(data)
+}
+
+// typedBackend upcasts the events read from a backend. An event is upcast the
+// first time it's read and kept, the backend still holds it as it was stored.
+type typedBackend struct {
+	Backend
+	types *TypeRegistry
+
+	mu sync.Mutex
+	// upcast holds the events that weren't up to date by Id, brought up to date
+	upcast map[int]Event
+}
+
+func newTypedBackend(b Backend, types *TypeRegistry) *typedBackend {
+	return &typedBackend{Backend: b, types: types, upcast: map[int]Event{}}
+}
+
+func (b *typedBackend) ReadAll() ([]Event, error) {
+	events, err := b.Backend.ReadAll()
+	return b.upcastAll(events), err
+}
+
+func (b *typedBackend) ReadStream(aggregateID string, fromVersion int) ([]Event, error) {
+	events, err := b.Backend.ReadStream(aggregateID, fromVersion)
+	return b.upcastAll(events), err
+}
+
+// upcastAll returns events brought up to date, without copying them if they already are
+func (b *typedBackend) upcastAll(events []Event) []Event {
+	for i := range events {
+		if b.types.current(events[i]) {
+			continue
+		}
+		upcast := make([]Event, len(events))
+		copy(upcast, events)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for j := i; j < len(upcast); j++ {
+			if !b.types.current(upcast[j]) {
+				upcast[j] = b.upcastLocked(upcast[j])
+			}
+		}
+		return upcast
+	}
+	return events
+}
+
+// upcastLocked must be called holding b.mu
+func (b *typedBackend) upcastLocked(ev Event) Event {
+	if upcast, ok := b.upcast[ev.Id]; ok {
+		return upcast
+	}
+	upcast, err := b.types.Upcast(ev)
+	if err != nil {
+		// it's kept as it is so the failure is only logged once
+		log.Println("store: can't upcast", ev, err)
+		upcast = ev
+	}
+	b.upcast[ev.Id] = upcast
+	return upcast
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type greeting struct {
+	Name string
+}
+
+func greetingTypes(eventType int) *TypeRegistry {
+	r := NewTypeRegistry()
+	r.Register(EventType{
+		Type:    eventType,
+		Name:    "Greeted",
+		Version: 2,
+		New:     func() interface{} { return &greeting{} },
+		Upcasters: map[int]Upcaster{
+			1: func(data string) (string, error) {
+				return EncodePayload(greeting{Name: strings.TrimPrefix(data, "hello ")}), nil
+			},
+		},
+	})
+	return r
+}
+
+func TestTypesUpcastStoredEvents(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// an event written before the store knew about types
+	l, _, err := OpenFileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(Event{Id: 0, AggregateID: "some game", EventType: 1, EventData: "hello bob", Version: 1})
+	l.Close()
+
+	s, err := NewFileEventStore(dir, greetingTypes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := s.AddEvent(Event{AggregateID: "some game", EventType: 1, EventData: EncodePayload(greeting{Name: "alice"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.TypeName != "Greeted" || ev.SchemaVersion != 2 {
+		t.Error("expected the event to be stamped with its type but got", ev)
+	}
+	if _, err := s.AddEvent(Event{AggregateID: "some game", EventType: 2}); err == nil {
+		t.Error("expected appending an unknown type to fail")
+	}
+	s.backend.(*typedBackend).Backend.(*FileBackend).Close()
+
+	types := greetingTypes(1)
+	restarted, err := NewFileEventStore(dir, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ev := range restarted.ReadStream("some game", 0) {
+		if ev.TypeName != "Greeted" || ev.SchemaVersion != 2 {
+			t.Error("expected an up to date event but got", ev)
+		}
+		payload, err := types.Decode(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, payload.(*greeting).Name)
+	}
+	if !reflect.DeepEqual(names, []string{"bob", "alice"}) {
+		t.Error("expected to greet bob and alice but got", names)
+	}
+}
+
+func TestTypesGoByName(t *testing.T) {
+	stored, err := greetingTypes(1).stamp(Event{EventType: 1, EventData: EncodePayload(greeting{Name: "bob"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the type was renumbered since the event was stored
+	ev, err := greetingTypes(7).Upcast(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.EventType != 7 {
+		t.Error("expected the event to take the type's new number but got", ev)
+	}
+}
+
+func TestTypesUpcastEachEventOnce(t *testing.T) {
+	b := NewMemoryBackend()
+	b.Append(Event{Id: 0, AggregateID: "some game", EventType: 1, EventData: "hello bob", Version: 1})
+
+	upcasts := 0
+	types := NewTypeRegistry()
+	types.Register(EventType{Type: 1, Name: "Greeted", Version: 2,
+		New: func() interface{} { return &greeting{} },
+		Upcasters: map[int]Upcaster{1: func(data string) (string, error) {
+			upcasts++
+			return EncodePayload(greeting{Name: strings.TrimPrefix(data, "hello ")}), nil
+		}},
+	})
+	s := NewBackendEventStore(b, types)
+	for i := 0; i < 3; i++ {
+		s.AddEvent(Event{AggregateID: "some game", EventType: 1, EventData: EncodePayload(greeting{Name: "alice"})})
+		s.ReadStream("some game", 0)
+		s.Events()
+	}
+	if upcasts != 1 {
+		t.Error("expected the stored event to be upcast once but it was", upcasts, "times")
+	}
+}